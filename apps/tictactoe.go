@@ -439,23 +439,7 @@ func (t *TicTacToeApp) showBoard() {
 	boardFlattened := ""
 	for i := 0; i < 3; i++ {
 		for j := 0; j < 3; j++ {
-			var cell string
-			switch b[i][j].Player {
-			case 0:
-				cell = "."
-			case 1:
-				if b[i][j].Power == 1 {
-					cell = "X"
-				} else {
-					cell = fmt.Sprintf("X%d", b[i][j].Power)
-				}
-			case 2:
-				if b[i][j].Power == 1 {
-					cell = "O"
-				} else {
-					cell = fmt.Sprintf("O%d", b[i][j].Power)
-				}
-			}
+			cell := b[i][j].String()
 			board += cell
 			boardFlattened += cell
 			if j < 2 {
@@ -488,39 +472,9 @@ func (t *TicTacToeApp) showBoard() {
 	t.engine.Out(fmt.Sprintf("Current Turn: Player %d", t.engine.TTT().GetCurrentPlayer()))
 
 	// Add phase information
-	phase := t.engine.TTT().GetCurrentPhase()
-	phaseStr := "Assignment"
-	if phase == states.PhaseMovement {
-		phaseStr = "Movement (optional)"
-	}
-	t.engine.Out(fmt.Sprintf("Current Phase: %s", phaseStr))
+	t.engine.Out(fmt.Sprintf("Current Phase: %s", t.engine.TTT().GetCurrentPhase()))
 }
 
 func (t *TicTacToeApp) CountLines(player int) int {
-	b := t.engine.TTT().GetBoard()
-	count := 0
-
-	// Check rows
-	for i := 0; i < 3; i++ {
-		if b[i][0].Player == player && b[i][1].Player == player && b[i][2].Player == player {
-			count++
-		}
-	}
-
-	// Check columns
-	for i := 0; i < 3; i++ {
-		if b[0][i].Player == player && b[1][i].Player == player && b[2][i].Player == player {
-			count++
-		}
-	}
-
-	// Check diagonals
-	if b[0][0].Player == player && b[1][1].Player == player && b[2][2].Player == player {
-		count++
-	}
-	if b[0][2].Player == player && b[1][1].Player == player && b[2][0].Player == player {
-		count++
-	}
-
-	return count
+	return t.engine.TTT().CountLines(player)
 }
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/ivorytoast/replay78/engine"
+	"github.com/ivorytoast/replay78/engine/logdiff"
+	"github.com/ivorytoast/replay78/engine/testfile"
+)
+
+const (
+	baselineDir = "fuzz_baselines"
+	fuzzTestDir = "fuzz_tests"
+)
+
+// baselinePath is where the baseline of a test file lives
+func baselinePath(testFile string) string {
+	name := strings.TrimSuffix(filepath.Base(testFile), ".txt")
+	return filepath.Join(baselineDir, name+".log")
+}
+
+// recordBaseline runs a test file's inputs on an in-memory engine and
+// returns the log it writes. It finishes before returning, so a baseline
+// is complete as soon as it is on disk. Inputs are recorded without their
+// file source, so a baseline does not depend on where the test file was
+// read from.
+func recordBaseline(testFile string) ([]byte, error) {
+	test, err := testfile.Parse(testFile)
+	if err != nil {
+		return nil, err
+	}
+	sink := engine.NewMemorySink()
+	e := engine.NewEngineWithSink(sink)
+	e.SetReplayMode()
+	setupEngine(e)
+	for _, input := range test.Inputs {
+		input.Source = engine.Source{}
+		e.Apply(input)
+	}
+	return sink.Bytes(), nil
+}
+
+// updateBaselines handles --regression --update (names empty, every
+// selected test) and --approve (a comma-separated list of test names). It
+// shows how each baseline changes before rewriting it and reports whether
+// all succeeded.
+func updateBaselines(names string, selection testSelection, diffContext int) bool {
+	var testFiles []string
+	if names == "" {
+		tests, _ := selectTests(selection)
+		for _, test := range tests {
+			testFiles = append(testFiles, test.Path)
+		}
+	} else {
+		for _, name := range strings.Split(names, ",") {
+			name = strings.TrimSuffix(strings.TrimSuffix(strings.TrimSpace(name), ".txt"), ".log")
+			testFiles = append(testFiles, filepath.Join(fuzzTestDir, filepath.Base(name)+".txt"))
+		}
+	}
+	if len(testFiles) == 0 {
+		fmt.Printf("No tests selected in %s\n", fuzzTestDir)
+		return false
+	}
+	os.MkdirAll(baselineDir, 0755)
+
+	ok := true
+	for _, testFile := range testFiles {
+		if err := approveBaseline(testFile, diffContext); err != nil {
+			fmt.Printf("  ❌ %s: %v\n", filepath.Base(testFile), err)
+			ok = false
+		}
+	}
+	return ok
+}
+
+func approveBaseline(testFile string, diffContext int) error {
+	data, err := recordBaseline(testFile)
+	if err != nil {
+		return err
+	}
+	baseline := baselinePath(testFile)
+
+	if _, err := os.Stat(baseline); os.IsNotExist(err) {
+		fmt.Printf("  ➕ %s: new baseline %s\n", filepath.Base(testFile), baseline)
+		return os.WriteFile(baseline, data, 0644)
+	}
+
+	// Diff through a temporary file next to the baseline, which then
+	// replaces it in one rename
+	tmp := strings.TrimSuffix(baseline, ".log") + ".approve.log"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	report, err := logdiff.Compare(baseline, tmp, diffContext)
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if report.Equal {
+		os.Remove(tmp)
+		fmt.Printf("  ✅ %s: baseline unchanged\n", filepath.Base(testFile))
+		return nil
+	}
+	fmt.Printf("  ✏️  %s: baseline updated (%d records removed, %d added)\n", filepath.Base(testFile), report.Deleted, report.Inserted)
+	report.WriteUnified(os.Stdout)
+	return os.Rename(tmp, baseline)
+}
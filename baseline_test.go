@@ -0,0 +1,25 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"testing"
+)
+
+func TestRecordBaselineMatchesCommittedBaseline(t *testing.T) {
+	testFile := "fuzz_tests/complete_game_with_combat_test.txt"
+	want, err := os.ReadFile(baselinePath(testFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := recordBaseline(testFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(got, []byte("src=")) {
+		t.Errorf("baseline records the test file's source:\n%s", got)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("recorded baseline differs from %s, so --update would report changes", baselinePath(testFile))
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/ivorytoast/replay78/engine/timetravel"
+)
+
+// runBisect handles "bisect <log file>", replaying the log and reporting
+// the first input whose outputs or state no longer match it
+func runBisect(args []string) {
+	if len(args) != 1 {
+		fmt.Println("Usage: replay78 bisect <log file>")
+		os.Exit(2)
+	}
+	d, err := bisectLog(args[0])
+	if err != nil {
+		fmt.Printf("Error bisecting %s: %v\n", args[0], err)
+		os.Exit(1)
+	}
+	if d == nil {
+		fmt.Printf("Replay of %s matches the original\n", args[0])
+		return
+	}
+	printDivergence(os.Stdout, d, "")
+	os.Exit(1)
+}
+
+func bisectLog(logFile string) (*timetravel.Divergence, error) {
+	return timetravel.FirstDivergence(logFile, setupEngine)
+}
+
+// printDivergence writes the input, expected vs actual records and the
+// state diff, each line starting with indent
+func printDivergence(w io.Writer, d *timetravel.Divergence, indent string) {
+	what := "outputs"
+	if !d.OutputsDiffer() {
+		what = "state"
+	}
+	fmt.Fprintf(w, "%sFirst divergence at input #%d (seq %d), %s differ\n", indent, d.Index+1, d.Seq, what)
+	fmt.Fprintf(w, "%s  Input: %s\n", indent, d.Input.Line)
+	if d.Partition != "" {
+		fmt.Fprintf(w, "%s  Game: %s\n", indent, d.Partition)
+	}
+
+	fmt.Fprintf(w, "%s  Expected:\n", indent)
+	for _, record := range d.Expected {
+		fmt.Fprintf(w, "%s    %s\n", indent, record)
+	}
+	fmt.Fprintf(w, "%s  Actual:\n", indent)
+	for _, record := range d.Actual {
+		fmt.Fprintf(w, "%s    %s\n", indent, record)
+	}
+
+	diff := d.StateDiff()
+	if d.ExpectedState != nil {
+		fmt.Fprintf(w, "%s  State (expected -> actual, hash %s -> %s):\n", indent, d.ExpectedState.Hash(), d.After.Hash())
+	} else {
+		fmt.Fprintf(w, "%s  State change made by the input (no snapshot to compare with):\n", indent)
+	}
+	if len(diff) == 0 {
+		fmt.Fprintf(w, "%s    (none)\n", indent)
+	}
+	for _, line := range diff {
+		fmt.Fprintf(w, "%s    %s\n", indent, line)
+	}
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/ivorytoast/replay78/apps"
+	"github.com/ivorytoast/replay78/engine"
+	"github.com/ivorytoast/replay78/engine/raft"
+	"github.com/ivorytoast/replay78/engine/testfile"
+)
+
+// Runs a three node replay78 cluster in one process, drives it with a test
+// file through whichever node is leader, and checks every node ends up
+// with an identical log.
+func main() {
+	inputFile := flag.String("input", "fuzz_tests/complete_game_with_combat_test.txt", "Test file to feed the cluster")
+	useTCP := flag.Bool("tcp", false, "Use the TCP transport on localhost instead of the in-memory one")
+	dropRate := flag.Float64("drop", 0, "In-memory only: probability of dropping each message")
+	isolateAt := flag.Int("isolate-leader-at", 0, "In-memory only: disconnect the leader after this many inputs (0 = never)")
+	flag.Parse()
+
+	test, err := testfile.Parse(*inputFile)
+	if err != nil {
+		fmt.Printf("Error reading %s: %v\n", *inputFile, err)
+		os.Exit(1)
+	}
+	inputs := test.Inputs
+
+	ids := []string{"node1", "node2", "node3"}
+	memory := raft.NewMemoryTransport()
+	memory.SetDropRate(*dropRate)
+	tcp := &raft.TCPTransport{Addrs: make(map[string]string)}
+	var transport raft.Transport = memory
+	if *useTCP {
+		transport = tcp
+		for i, id := range ids {
+			tcp.Addrs[id] = fmt.Sprintf("127.0.0.1:%d", 7801+i)
+		}
+	}
+
+	var replicas []*raft.Replica
+	for _, id := range ids {
+		var peers []string
+		for _, other := range ids {
+			if other != id {
+				peers = append(peers, other)
+			}
+		}
+
+		e := engine.NewEngineWithLogFile("raft-" + id + ".log")
+		e.RegisterApplication(apps.NewTicTacToeApp(e))
+		replica := raft.NewReplica(id, peers, transport, e)
+		if *useTCP {
+			if _, err := raft.ServeTCP(tcp.Addrs[id], replica.Node()); err != nil {
+				fmt.Printf("Error listening for %s: %v\n", id, err)
+				os.Exit(1)
+			}
+		} else {
+			memory.Register(id, replica.Node())
+		}
+		replicas = append(replicas, replica)
+	}
+	for _, replica := range replicas {
+		replica.Engine().Run()
+		replica.Start()
+	}
+
+	isolated := ""
+	for i, input := range inputs {
+		if *isolateAt > 0 && !*useTCP {
+			if i == *isolateAt {
+				isolated = leader(replicas).Node().ID()
+				fmt.Printf("Isolating leader %s\n", isolated)
+				memory.Disconnect(isolated)
+			} else if i == 2**isolateAt {
+				fmt.Printf("Reconnecting %s\n", isolated)
+				memory.Reconnect(isolated)
+			}
+		}
+
+		// The key makes retries safe if an earlier attempt did commit
+		input.Source = engine.Source{Kind: "file", ID: *inputFile}
+		input.Key = fmt.Sprintf("line-%d", i)
+		if err := submit(replicas, input); err != nil {
+			fmt.Printf("Giving up on %q: %v\n", input.Line, err)
+			os.Exit(1)
+		}
+	}
+	memory.Heal()
+
+	if converged(replicas, 5*time.Second) {
+		fmt.Printf("✅ All %d nodes agree on %d inputs\n", len(replicas), len(inputs))
+	} else {
+		fmt.Println("❌ Node logs differ")
+		os.Exit(1)
+	}
+}
+
+// leader waits until some replica believes it leads the cluster. An
+// isolated old leader may still think it does, so the newest term wins.
+func leader(replicas []*raft.Replica) *raft.Replica {
+	for {
+		var best *raft.Replica
+		bestTerm := 0
+		for _, replica := range replicas {
+			state, term, _ := replica.Node().Status()
+			if state == raft.Leader && term > bestTerm {
+				best = replica
+				bestTerm = term
+			}
+		}
+		if best != nil {
+			return best
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func submit(replicas []*raft.Replica, input engine.Input) error {
+	var err error
+	for attempt := 0; attempt < 20; attempt++ {
+		if _, err = leader(replicas).Engine().SubmitWait(input); err == nil {
+			return nil
+		}
+		if !errors.Is(err, raft.ErrNotLeader) {
+			fmt.Printf("Retrying %q: %v\n", input.Line, err)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	return err
+}
+
+func converged(replicas []*raft.Replica, timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		first, err := os.ReadFile(replicas[0].Engine().LogFile())
+		same := err == nil
+		for _, replica := range replicas[1:] {
+			other, err := os.ReadFile(replica.Engine().LogFile())
+			if err != nil || !bytes.Equal(first, other) {
+				same = false
+			}
+		}
+		if same {
+			return true
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	return false
+}
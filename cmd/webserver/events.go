@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/ivorytoast/replay78/engine"
+	"github.com/ivorytoast/replay78/engine/logreader"
+)
+
+const (
+	eventBuffer       = 1024
+	eventPingInterval = 15 * time.Second
+)
+
+// publishRecord runs on the loop of engine e for every record it logs. A
+// client that falls too far behind is cut off and can resume with
+// Last-Event-ID.
+func (gs *GameServer) publishRecord(e *engine.Engine, record string) {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	for sub, following := range gs.subscribers {
+		if following != e {
+			continue
+		}
+		select {
+		case sub <- record:
+		default:
+			delete(gs.subscribers, sub)
+			close(sub)
+		}
+	}
+}
+
+// GET /events streams I and O records as Server-Sent Events, using the seq
+// as the event id. Resumes after Last-Event-ID (or ?from=seq) by replaying
+// the log before switching to live records. With ?game=id it follows the
+// engine running that game, which under -shards is one shard's log.
+func (gs *GameServer) handleEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, "streaming unsupported")
+		return
+	}
+
+	last := 0
+	if id := r.Header.Get("Last-Event-ID"); id != "" {
+		last, _ = strconv.Atoi(id)
+	} else if from := r.URL.Query().Get("from"); from != "" {
+		n, _ := strconv.Atoi(from)
+		last = n - 1
+	}
+
+	// Subscribe before reading the log so nothing written meanwhile is missed
+	e := gs.engineFor(r.URL.Query().Get("game"))
+	sub := make(chan string, eventBuffer)
+	gs.mu.Lock()
+	gs.subscribers[sub] = e
+	gs.mu.Unlock()
+	defer func() {
+		gs.mu.Lock()
+		if _, ok := gs.subscribers[sub]; ok {
+			delete(gs.subscribers, sub)
+			close(sub)
+		}
+		gs.mu.Unlock()
+	}()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+
+	send := func(record logRecord) error {
+		if record.Seq <= last {
+			return nil
+		}
+		last = record.Seq
+		event := "output"
+		if record.Kind == "I" {
+			event = "input"
+		}
+		data, _ := json.Marshal(record)
+		_, err := fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", record.Seq, event, data)
+		return err
+	}
+
+	// A last line still mid-write is skipped, the live stream carries it
+	history, err := logreader.Open(e.LogFile())
+	if err == nil {
+		defer history.Close()
+		err = history.Seek(last + 1)
+	}
+	if err != nil {
+		log.Printf("Events catch-up failed: %v", err)
+		return
+	}
+	for history.Next() {
+		if err := send(newLogRecord(history.Record())); err != nil {
+			return
+		}
+	}
+	flusher.Flush()
+
+	ping := time.NewTicker(eventPingInterval)
+	defer ping.Stop()
+	for {
+		select {
+		case line, ok := <-sub:
+			if !ok {
+				return
+			}
+			record, valid := parseLogRecord(line)
+			if !valid {
+				continue
+			}
+			if err := send(record); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-ping.C:
+			if _, err := fmt.Fprint(w, ": ping\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
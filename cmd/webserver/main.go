@@ -2,13 +2,17 @@ package main
 
 import (
 	"encoding/json"
+	"flag"
 	"fmt"
 	"github.com/ivorytoast/replay78/apps"
 	"github.com/ivorytoast/replay78/engine"
+	"github.com/ivorytoast/replay78/engine/shard"
+	"github.com/ivorytoast/replay78/states"
 	"log"
 	"net/http"
+	"os"
+	"strconv"
 	"sync"
-	"time"
 
 	"github.com/gorilla/websocket"
 )
@@ -20,29 +24,49 @@ var upgrader = websocket.Upgrader{
 }
 
 type GameServer struct {
-	engine  *engine.Engine
-	app     *apps.TicTacToeApp
-	clients map[*websocket.Conn]bool
-	mu      sync.Mutex
+	inputs      engine.Submitter                 // The engine, or the runtime spreading games over shards
+	engineFor   func(game string) *engine.Engine // Engine that runs a game, see engine.Input.Partition
+	clients     map[*websocket.Conn]bool
+	subscribers map[chan string]*engine.Engine // Server-Sent Events streams and the engine each follows
+	adminToken  string                         // Lets POST /inputs send engine commands, see isAdmin
+	nextID      int
+	mu          sync.Mutex
 }
 
 type Message struct {
 	Type    string `json:"type"`
 	Payload string `json:"payload"`
+	Key     string `json:"key,omitempty"` // Idempotency key, lets clients that connect with a user safely resend after a reconnect
 }
 
-func NewGameServer() *GameServer {
-	e := engine.NewEngine()
-	app := apps.NewTicTacToeApp(e)
-	e.RegisterApplication(app)
+// NewGameServer runs one engine, or with shards above 1 a shard.Runtime of
+// that many engines, each logging to 78-shard<i>.log
+func NewGameServer(snapshotEvery int, shards int, adminToken string) *GameServer {
+	gs := &GameServer{
+		adminToken:  adminToken,
+		clients:     make(map[*websocket.Conn]bool),
+		subscribers: make(map[chan string]*engine.Engine),
+	}
+	setup := func(e *engine.Engine) {
+		e.RegisterApplication(apps.NewTicTacToeApp(e))
+		e.SetSnapshotInterval(snapshotEvery)
+		e.OnRecord(func(record string) { gs.publishRecord(e, record) })
+	}
 
+	if shards > 1 {
+		runtime := shard.NewRuntime("78", shards, setup)
+		gs.inputs, gs.engineFor = runtime, runtime.Engine
+		runtime.Run()
+		return gs
+	}
+
+	e := engine.NewEngine()
+	setup(e)
+	gs.inputs = e
+	gs.engineFor = func(string) *engine.Engine { return e }
 	e.Run()
 
-	return &GameServer{
-		engine:  e,
-		app:     app,
-		clients: make(map[*websocket.Conn]bool),
-	}
+	return gs
 }
 
 func (gs *GameServer) handleWebSocket(w http.ResponseWriter, r *http.Request) {
@@ -55,10 +79,17 @@ func (gs *GameServer) handleWebSocket(w http.ResponseWriter, r *http.Request) {
 
 	gs.mu.Lock()
 	gs.clients[conn] = true
+	gs.nextID++
+	source := engine.Source{
+		Kind: "ws",
+		ID:   strconv.Itoa(gs.nextID),
+		User: r.URL.Query().Get("user"),
+	}
+	game := r.URL.Query().Get("game")
 	gs.mu.Unlock()
 
 	// Send initial board state
-	gs.sendBoardState(conn)
+	gs.sendBoardState(conn, game)
 
 	for {
 		var msg Message
@@ -71,33 +102,64 @@ func (gs *GameServer) handleWebSocket(w http.ResponseWriter, r *http.Request) {
 			break
 		}
 
-		gs.handleMessage(conn, msg)
+		gs.handleMessage(conn, source, game, msg)
 	}
 }
 
-func (gs *GameServer) handleMessage(conn *websocket.Conn, msg Message) {
+func (gs *GameServer) handleMessage(conn *websocket.Conn, source engine.Source, game string, msg Message) {
+	var input string
 	switch msg.Type {
 	case "move":
-		gs.engine.In("ttt|move|" + msg.Payload)
-		// Give the engine a moment to process
-		// In a real implementation, we'd use channels or callbacks
-		time.Sleep(50 * time.Millisecond)
-		gs.sendBoardState(conn)
+		input = "ttt|move|" + msg.Payload
 	case "endturn":
-		gs.engine.In("ttt|endturn|")
-		time.Sleep(50 * time.Millisecond)
-		gs.sendBoardState(conn)
+		input = "ttt|endturn|"
 	case "new":
-		gs.engine.In("ttt|new|")
-		time.Sleep(50 * time.Millisecond)
-		gs.sendBoardState(conn)
+		input = "ttt|new|"
 	case "show":
-		gs.sendBoardState(conn)
+		gs.sendBoardState(conn, game)
+		return
+	default:
+		return
 	}
+
+	// Never wait for queue space, but do wait for the result, which for a
+	// repeated key is the one the original input got
+	result, err := gs.inputs.TrySubmitWait(engine.Input{Line: input, Source: source, Key: msg.Key, Partition: game})
+	if err != nil {
+		gs.sendError(conn, err)
+		return
+	}
+	gs.sendResult(conn, result)
+	gs.sendBoardState(conn, game)
+}
+
+func (gs *GameServer) sendResult(conn *websocket.Conn, result engine.Result) {
+	data, _ := json.Marshal(map[string]interface{}{
+		"type":      "result",
+		"seq":       result.Seq,
+		"outputs":   result.Outputs,
+		"duplicate": result.Duplicate,
+	})
+	conn.WriteMessage(websocket.TextMessage, data)
 }
 
-func (gs *GameServer) sendBoardState(conn *websocket.Conn) {
-	state := gs.engine.TTT()
+func (gs *GameServer) sendError(conn *websocket.Conn, err error) {
+	data, _ := json.Marshal(map[string]interface{}{
+		"type":    "error",
+		"message": err.Error(),
+	})
+	conn.WriteMessage(websocket.TextMessage, data)
+}
+
+func (gs *GameServer) sendBoardState(conn *websocket.Conn, game string) {
+	e := gs.engineFor(game)
+	var state *states.TicTacToeState
+	if err := e.Inspect(func() {
+		state = e.PartitionState(game).Clone()
+	}); err != nil {
+		gs.sendError(conn, err)
+		return
+	}
 	board := state.GetBoard()
 
 	type CellData struct {
@@ -117,8 +179,8 @@ func (gs *GameServer) sendBoardState(conn *websocket.Conn) {
 	}
 
 	// Count lines for each player
-	player1Lines := gs.app.CountLines(1)
-	player2Lines := gs.app.CountLines(2)
+	player1Lines := state.CountLines(1)
+	player2Lines := state.CountLines(2)
 
 	response := map[string]interface{}{
 		"type":             "board_state",
@@ -138,9 +200,16 @@ func (gs *GameServer) sendBoardState(conn *websocket.Conn) {
 }
 
 func main() {
-	gs := NewGameServer()
+	snapshotEvery := flag.Int("snapshot-every", 100, "Write an engine snapshot after this many inputs (0 = never)")
+	shards := flag.Int("shards", 1, "Spread games over this many engines, each logging to 78-shard<i>.log")
+	adminToken := flag.String("admin-token", os.Getenv("REPLAY78_ADMIN_TOKEN"), "Bearer token that lets POST /inputs send engine commands (default $REPLAY78_ADMIN_TOKEN, none when empty)")
+	flag.Parse()
+
+	gs := NewGameServer(*snapshotEvery, *shards, *adminToken)
 
 	http.HandleFunc("/ws", gs.handleWebSocket)
+	gs.registerREST(http.DefaultServeMux)
+	http.HandleFunc("/events", gs.handleEvents)
 	http.Handle("/", http.FileServer(http.Dir("./web")))
 
 	fmt.Println("Server starting on :8080")
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/ivorytoast/replay78/engine"
+	"github.com/ivorytoast/replay78/engine/logreader"
+	"github.com/ivorytoast/replay78/states"
+)
+
+const defaultLogPageSize = 100
+
+type inputRequest struct {
+	Line string `json:"line"` // topic|action|payload
+	Key  string `json:"key,omitempty"`
+	User string `json:"user,omitempty"`
+	Game string `json:"game,omitempty"` // See engine.Input.Partition
+}
+
+// logRecord is the JSON form of one log line
+type logRecord struct {
+	Seq       int    `json:"seq"`
+	Kind      string `json:"kind"` // I or O
+	Topic     string `json:"topic,omitempty"`
+	Action    string `json:"action,omitempty"`
+	Payload   string `json:"payload,omitempty"`
+	Source    string `json:"source,omitempty"`
+	User      string `json:"user,omitempty"`
+	Key       string `json:"key,omitempty"`
+	Text      string `json:"text,omitempty"` // Output text
+	Partition string `json:"partition,omitempty"`
+}
+
+func (gs *GameServer) registerREST(mux *http.ServeMux) {
+	mux.HandleFunc("/inputs", gs.handleInputs)
+	mux.HandleFunc("/log", gs.handleLog)
+	mux.HandleFunc("/state", gs.handleState)
+	mux.HandleFunc("/snapshots", gs.handleSnapshots)
+}
+
+// POST /inputs submits one input and returns its seq and outputs. Engine
+// commands are only taken with "Authorization: Bearer <admin token>".
+func (gs *GameServer) handleInputs(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "use POST")
+		return
+	}
+	var req inputRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid JSON: "+err.Error())
+		return
+	}
+
+	if engine.IsEngineCommand(req.Line) && !gs.isAdmin(r) {
+		writeError(w, http.StatusForbidden, "engine commands need the admin token")
+		return
+	}
+
+	input := engine.Input{
+		Line:      req.Line,
+		Key:       req.Key,
+		Source:    engine.Source{Kind: "http", ID: r.RemoteAddr, User: req.User},
+		Partition: req.Game,
+	}
+	result, err := gs.inputs.TrySubmitWait(input)
+	if errors.Is(err, engine.ErrQueueFull) {
+		writeError(w, http.StatusServiceUnavailable, err.Error())
+		return
+	} else if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if result.Seq == 0 {
+		writeJSON(w, http.StatusBadRequest, result)
+		return
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+// GET /log?from=seq&limit=n pages through the current log file, of the
+// engine running ?game=id if given
+func (gs *GameServer) handleLog(w http.ResponseWriter, r *http.Request) {
+	from, _ := strconv.Atoi(r.URL.Query().Get("from"))
+	limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+	if err != nil || limit <= 0 {
+		limit = defaultLogPageSize
+	}
+
+	reader, err := logreader.Open(gs.engineFor(r.URL.Query().Get("game")).LogFile())
+	if err == nil {
+		defer reader.Close()
+		err = reader.Seek(from)
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	records := []logRecord{}
+	next := 0
+	for reader.Next() {
+		record := newLogRecord(reader.Record())
+		if len(records) == limit {
+			next = record.Seq
+			break
+		}
+		records = append(records, record)
+	}
+
+	response := map[string]interface{}{"records": records}
+	if next != 0 {
+		response["next"] = next
+	}
+	writeJSON(w, http.StatusOK, response)
+}
+
+func parseLogRecord(line string) (logRecord, bool) {
+	record, err := logreader.Parse(line)
+	if err != nil {
+		return logRecord{}, false
+	}
+	return newLogRecord(record), true
+}
+
+func newLogRecord(record logreader.Record) logRecord {
+	if record.Kind == "I" {
+		input := record.Input
+		fields := strings.SplitN(input.Line, "|", 3)
+		for len(fields) < 3 {
+			fields = append(fields, "")
+		}
+		return logRecord{
+			Seq:       record.Seq,
+			Kind:      "I",
+			Topic:     fields[0],
+			Action:    fields[1],
+			Payload:   fields[2],
+			Source:    input.Source.String(),
+			User:      input.Source.User,
+			Key:       input.Key,
+			Partition: input.Partition,
+		}
+	}
+	return logRecord{Seq: record.Seq, Kind: record.Kind, Text: record.Text}
+}
+
+// GET /state returns the game as of the last processed input, the one
+// named by ?game=id if given
+func (gs *GameServer) handleState(w http.ResponseWriter, r *http.Request) {
+	game := r.URL.Query().Get("game")
+	e := gs.engineFor(game)
+	var seq int
+	var state *states.TicTacToeState
+	err := e.Inspect(func() {
+		seq = e.Seq()
+		state = e.PartitionState(game).Clone()
+	})
+	if err != nil {
+		writeError(w, http.StatusServiceUnavailable, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"seq":       seq,
+		"tictactoe": state,
+	})
+}
+
+// GET /snapshots lists the snapshot files of the current log, and
+// GET /snapshots?seq=n returns the one taken at seq n. Both take ?game=id
+// like /log.
+func (gs *GameServer) handleSnapshots(w http.ResponseWriter, r *http.Request) {
+	files, err := engine.ListSnapshots(gs.engineFor(r.URL.Query().Get("game")).LogFile())
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	if seqParam := r.URL.Query().Get("seq"); seqParam != "" {
+		for _, file := range files {
+			snapshot, err := engine.LoadSnapshot(file)
+			if err == nil && strconv.Itoa(snapshot.Seq) == seqParam {
+				writeJSON(w, http.StatusOK, snapshot)
+				return
+			}
+		}
+		writeError(w, http.StatusNotFound, "no snapshot at seq "+seqParam)
+		return
+	}
+
+	type snapshotInfo struct {
+		Seq  int    `json:"seq"`
+		File string `json:"file"`
+	}
+	list := []snapshotInfo{}
+	for _, file := range files {
+		snapshot, err := engine.LoadSnapshot(file)
+		if err != nil {
+			continue
+		}
+		list = append(list, snapshotInfo{Seq: snapshot.Seq, File: file})
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+// isAdmin reports whether the request carries the admin token. Without a
+// token configured nobody is.
+func (gs *GameServer) isAdmin(r *http.Request) bool {
+	if gs.adminToken == "" {
+		return false
+	}
+	given := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return subtle.ConstantTimeCompare([]byte(given), []byte(gs.adminToken)) == 1
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"error": message})
+}
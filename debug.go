@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/ivorytoast/replay78/engine/timetravel"
+)
+
+const debugHelp = `Commands:
+  s, step              apply the next input
+  n, next <count>      apply the next count inputs
+  b, back [count]      undo the last count inputs (default 1)
+  g, goto <seq>        move to just after the input that wrote seq
+  c, continue          run until a breakpoint or the end
+  break <field> <re>   break on inputs whose topic or action, or outputs, match re
+  breaks               list breakpoints
+  clear                remove all breakpoints
+  l, list [count]      show upcoming inputs (default 5)
+  p, print             show the game state
+  h, help              show this help
+  q, quit              leave the debugger`
+
+// runDebugger handles "debug <log or test file>", replaying it one input at
+// a time under the user's control
+func runDebugger(args []string) {
+	if len(args) != 1 {
+		fmt.Println("Usage: replay78 debug <log or test file>")
+		os.Exit(2)
+	}
+	inputs, err := timetravel.LoadInputs(args[0])
+	if err != nil {
+		fmt.Printf("Error loading %s: %v\n", args[0], err)
+		os.Exit(1)
+	}
+
+	d := timetravel.NewDebugger(inputs, setupEngine)
+	fmt.Printf("Loaded %d inputs from %s. Type h for help.\n", d.Len(), args[0])
+	debugLoop(d, os.Stdin, os.Stdout)
+}
+
+// debugLoop reads debugger commands from in until quit or end of input,
+// writing prompts and results to out
+func debugLoop(d *timetravel.Debugger, in io.Reader, out io.Writer) {
+	scanner := bufio.NewScanner(in)
+	for {
+		fmt.Fprintf(out, "(%d/%d seq %d) ", d.Position(), d.Len(), d.Engine().Seq())
+		if !scanner.Scan() {
+			return
+		}
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		command, rest := fields[0], fields[1:]
+		// count parses the optional count argument. On a bad one it reports
+		// false and the command must not move the cursor.
+		count := func(def int) (int, bool) {
+			if len(rest) == 0 {
+				return def, true
+			}
+			n, err := strconv.Atoi(rest[0])
+			if err != nil || n < 0 {
+				fmt.Fprintf(out, "Invalid count %q\n", rest[0])
+				return 0, false
+			}
+			return n, true
+		}
+
+		switch command {
+		case "s", "step":
+			if step, ok := d.Step(); ok {
+				printStep(out, step, true)
+			} else {
+				fmt.Fprintln(out, "At the end of the inputs")
+			}
+		case "n", "next":
+			n, ok := count(1)
+			if !ok {
+				continue
+			}
+			for i := 0; i < n; i++ {
+				step, ok := d.Step()
+				if !ok {
+					fmt.Fprintln(out, "At the end of the inputs")
+					break
+				}
+				printStep(out, step, i == n-1)
+			}
+		case "b", "back":
+			n, ok := count(1)
+			if !ok {
+				continue
+			}
+			if step := d.Back(n); step != nil {
+				fmt.Fprint(out, "Back to after ")
+				printStep(out, step, false)
+			} else {
+				fmt.Fprintln(out, "Back at the start")
+			}
+		case "g", "goto":
+			seq, ok := count(-1)
+			if !ok {
+				continue
+			}
+			if seq < 0 {
+				fmt.Fprintln(out, "Usage: goto <seq>")
+				continue
+			}
+			if step := d.Goto(seq); step != nil {
+				printStep(out, step, true)
+			}
+		case "c", "continue":
+			step, hit := d.Continue()
+			if hit != nil {
+				fmt.Fprintf(out, "Breakpoint on %s\n", hit)
+			} else {
+				fmt.Fprintln(out, "At the end of the inputs")
+			}
+			if step != nil {
+				printStep(out, step, true)
+			}
+		case "break":
+			if len(rest) < 2 {
+				fmt.Fprintln(out, "Usage: break <topic|action|output> <regex>")
+				continue
+			}
+			if err := d.Break(rest[0], strings.Join(rest[1:], " ")); err != nil {
+				fmt.Fprintf(out, "Error: %v\n", err)
+			}
+		case "breaks":
+			for i, b := range d.Breakpoints() {
+				fmt.Fprintf(out, "%d: %s\n", i+1, b)
+			}
+		case "clear":
+			d.ClearBreakpoints()
+		case "l", "list":
+			n, ok := count(5)
+			if !ok {
+				continue
+			}
+			for i, input := range d.Upcoming(n) {
+				fmt.Fprintf(out, "  #%d %s\n", d.Position()+i+1, input.Line)
+			}
+		case "p", "print":
+			fmt.Fprint(out, d.Engine().TTT().Describe())
+		case "h", "help":
+			fmt.Fprintln(out, debugHelp)
+		case "q", "quit":
+			return
+		default:
+			fmt.Fprintln(out, "Unknown command, type h for help")
+		}
+	}
+}
+
+func printStep(out io.Writer, step *timetravel.Step, withOutputs bool) {
+	fmt.Fprintf(out, "#%d [seq %d] %s\n", step.Index+1, step.Seq, step.Input.Line)
+	if withOutputs {
+		for _, output := range step.Result.Outputs {
+			fmt.Fprintf(out, "    %s\n", output)
+		}
+	}
+}
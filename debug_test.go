@@ -0,0 +1,44 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/ivorytoast/replay78/engine"
+	"github.com/ivorytoast/replay78/engine/timetravel"
+)
+
+func TestDebugLoopInvalidCount(t *testing.T) {
+	inputs := []engine.Input{
+		{Line: "ttt|new|"},
+		{Line: "ttt|show|"},
+		{Line: "ttt|show|"},
+		{Line: "ttt|show|"},
+	}
+	tests := []struct {
+		command string
+		want    int // Position afterwards, starting from 2
+	}{
+		{"next 1", 3},
+		{"next abc", 2},
+		{"back", 1},
+		{"back abc", 2},
+		{"back -1", 2},
+		{"goto abc", 2},
+		{"goto", 2},
+		{"list abc", 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.command, func(t *testing.T) {
+			d := timetravel.NewDebugger(inputs, setupEngine)
+			d.Step()
+			d.Step()
+
+			var out strings.Builder
+			debugLoop(d, strings.NewReader(tt.command+"\n"), &out)
+			if got := d.Position(); got != tt.want {
+				t.Errorf("position = %d, want %d\n%s", got, tt.want, out.String())
+			}
+		})
+	}
+}
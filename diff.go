@@ -0,0 +1,52 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/ivorytoast/replay78/engine/logdiff"
+)
+
+// runDiff handles "diff [-json] [-context n] <original> <replay>", printing
+// how the second log differs from the first
+func runDiff(args []string) {
+	fs := flag.NewFlagSet("diff", flag.ExitOnError)
+	asJSON := fs.Bool("json", false, "Print the report as JSON")
+	context := fs.Int("context", 3, "Context lines around each change")
+	fs.Parse(args)
+	if fs.NArg() != 2 {
+		fmt.Println("Usage: replay78 diff [-json] [-context n] <original log> <replay log>")
+		os.Exit(2)
+	}
+
+	report, err := logdiff.Compare(fs.Arg(0), fs.Arg(1), *context)
+	if err != nil {
+		fmt.Printf("Error comparing logs: %v\n", err)
+		os.Exit(1)
+	}
+	if *asJSON {
+		data, _ := json.MarshalIndent(report, "", "  ")
+		fmt.Println(string(data))
+	} else if report.Equal {
+		fmt.Println("Logs match")
+	} else {
+		report.WriteUnified(os.Stdout)
+	}
+	if !report.Equal {
+		os.Exit(1)
+	}
+}
+
+// writeDiffJSON saves the reports of a regression run for CI dashboards
+func writeDiffJSON(path string, reports []*logdiff.Report) error {
+	if reports == nil {
+		reports = []*logdiff.Report{}
+	}
+	data, err := json.MarshalIndent(reports, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0644)
+}
@@ -0,0 +1,167 @@
+package engine
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// engineTopic is reserved for commands to the engine itself, e.g.
+// "engine|gen-pause|tick". They are logged and replayed like any other
+// input, so their outputs only ever depend on the log:
+//
+//	engine|snapshot|          write a snapshot file now
+//	engine|rotate|            continue the log in a new segment
+//	engine|apps|              list the applications and their topics
+//	engine|loglevel|<level>   set this engine's diagnostics level (debug, info, warn, error)
+//	engine|pause|             hold back every input but engine commands
+//	engine|resume|            dispatch queued inputs again
+//	engine|generators|        list generators and their state
+//	engine|gen-<verb>|<name>  start, stop, pause or resume a generator
+const engineTopic = "engine"
+
+// IsEngineCommand reports whether line is on the engine topic. Endpoints
+// open to players should refuse such lines from anyone they do not trust.
+func IsEngineCommand(line string) bool {
+	topic, _, _ := strings.Cut(line, "|")
+	return strings.TrimSpace(topic) == engineTopic
+}
+
+func (e *Engine) handleAdmin(action, payload string) {
+	switch action {
+	case "snapshot":
+		e.adminSnapshot()
+	case "rotate":
+		e.adminRotate()
+	case "apps":
+		e.listApps()
+	case "loglevel":
+		e.adminLogLevel(strings.TrimSpace(payload))
+	case "pause", "resume":
+		e.adminDispatch(action == "pause")
+	case "generators":
+		e.listGenerators()
+	case "gen-start", "gen-stop", "gen-pause", "gen-resume":
+		e.controlGenerator(strings.TrimPrefix(action, "gen-"), strings.TrimSpace(payload))
+	default:
+		e.Out("Unknown engine command: " + action)
+	}
+}
+
+func (e *Engine) adminSnapshot() {
+	if err := e.writeSnapshot(); err != nil {
+		e.Out("Snapshot failed: " + err.Error())
+		return
+	}
+	e.Out(fmt.Sprintf("Snapshot taken at seq %d", e.seq))
+}
+
+// adminRotate names only the segment number, since a replay writes its
+// segments under its own log file name
+func (e *Engine) adminRotate() {
+	segment, err := e.rotateLog()
+	if err != nil {
+		e.Out("Log rotation failed: " + err.Error())
+		return
+	}
+	e.Out(fmt.Sprintf("Log rotated to segment %d", segment))
+}
+
+func (e *Engine) listApps() {
+	topics := make([]string, 0, len(e.applications))
+	for topic := range e.applications {
+		topics = append(topics, topic)
+	}
+	sort.Strings(topics)
+	if len(topics) == 0 {
+		e.Out("No applications registered")
+	}
+	for _, topic := range topics {
+		e.Out(fmt.Sprintf("Topic %s: %T", topic, e.applications[topic]))
+	}
+}
+
+func (e *Engine) adminLogLevel(name string) {
+	level, err := ParseLogLevel(name)
+	if err != nil {
+		e.Out(fmt.Sprintf("Unknown log level %q, use debug, info, warn or error", name))
+		return
+	}
+	e.SetLogLevel(level)
+	e.Out("Log level set to " + level.String())
+}
+
+// adminDispatch pauses or resumes the player and tick lanes. A replay takes
+// inputs in logged order, which already reflects any pause, so it only
+// echoes the command.
+func (e *Engine) adminDispatch(pause bool) {
+	if pause == e.dispatchPaused {
+		if pause {
+			e.Out("Dispatch already paused")
+		} else {
+			e.Out("Dispatch already running")
+		}
+		return
+	}
+	e.dispatchPaused = pause
+	if !e.replayMode {
+		e.queue.setPaused(pause)
+	}
+	if pause {
+		e.Out("Dispatch paused, only engine commands are processed")
+	} else {
+		e.Out("Dispatch resumed")
+	}
+}
+
+func (e *Engine) listGenerators() {
+	if len(e.generators) == 0 {
+		e.Out("No generators registered")
+		return
+	}
+	for _, entry := range e.generators {
+		source := entry.gen.Source()
+		last := "no inputs yet"
+		if entry.lastSeq != 0 {
+			last = fmt.Sprintf("last input at seq %d", entry.lastSeq)
+		}
+		e.Out(fmt.Sprintf("Generator %s (%s): %s, %s", source.ID, source.Kind, entry.state, last))
+	}
+}
+
+// controlGenerator moves a generator between states. A replay engine only
+// tracks the state, a live one also starts or signals the generator.
+func (e *Engine) controlGenerator(verb, name string) {
+	entry := e.findGenerator("", name)
+	if entry == nil {
+		e.Out("Unknown generator: " + name)
+		return
+	}
+
+	var from, to GeneratorState
+	var done string
+	switch verb {
+	case "start":
+		from, to, done = GeneratorStopped, GeneratorRunning, "started"
+	case "stop":
+		from, to, done = entry.state, GeneratorStopped, "stopped"
+	case "pause":
+		from, to, done = GeneratorRunning, GeneratorPaused, "paused"
+	case "resume":
+		from, to, done = GeneratorPaused, GeneratorRunning, "resumed"
+	}
+	if entry.state != from || entry.state == to {
+		e.Out(fmt.Sprintf("Generator %s is %s, cannot %s it", name, entry.state, verb))
+		return
+	}
+
+	entry.state = to
+	if !e.replayMode {
+		if verb == "start" {
+			e.launchGenerator(entry)
+		} else {
+			entry.gen.Control().set(to)
+		}
+	}
+	e.Out(fmt.Sprintf("Generator %s %s", name, done))
+}
@@ -0,0 +1,202 @@
+package engine
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxCronCatchUp bounds how far back a clock jump is searched for missed
+// schedules, so a first tick after a long gap does not scan years of minutes
+const maxCronCatchUp = 24 * time.Hour
+
+// CronGenerator emits inputs on cron schedules. It never sleeps on the wall
+// clock: schedules are checked against the engine clock every time a tick is
+// processed, and due inputs are processed right after that tick. The log
+// therefore holds them in a fixed place, and a replay simply reads them back
+// since the generator is not started in replay mode.
+type CronGenerator struct {
+	GeneratorControl
+	Name string
+	Jobs []*CronJob
+
+	hooked bool
+}
+
+// CronJob is a single "minute hour day-of-month month day-of-week" schedule,
+// evaluated in UTC, and the input line it emits
+type CronJob struct {
+	Spec string
+	Line string
+
+	schedule *cronSchedule
+}
+
+func NewCronGenerator(name string) *CronGenerator {
+	return &CronGenerator{Name: name}
+}
+
+// AddJob schedules line on spec, which is either five cron fields or one
+// of @yearly, @monthly, @weekly, @daily (@midnight, @nightly) and @hourly
+func (g *CronGenerator) AddJob(spec, line string) error {
+	schedule, err := parseCronSpec(spec)
+	if err != nil {
+		return err
+	}
+	g.Jobs = append(g.Jobs, &CronJob{Spec: spec, Line: line, schedule: schedule})
+	return nil
+}
+
+func (g *CronGenerator) Source() Source {
+	return Source{Kind: "cron", ID: g.Name}
+}
+
+func (g *CronGenerator) Start(e *Engine) {
+	if !g.hooked {
+		e.onClock(g.due)
+		g.hooked = true
+	}
+}
+
+// due returns the inputs of every job scheduled in (prev, now]. Schedules
+// that come round while the generator is paused or stopped are skipped.
+func (g *CronGenerator) due(prev, now time.Time) []Input {
+	if prev.IsZero() || g.active() != nil {
+		return nil
+	}
+	if now.Sub(prev) > maxCronCatchUp {
+		prev = now.Add(-maxCronCatchUp)
+	}
+	var inputs []Input
+	for _, job := range g.Jobs {
+		if job.schedule.firesBetween(prev, now) {
+			inputs = append(inputs, Input{
+				Line:   job.Line,
+				Source: g.Source(),
+			})
+		}
+	}
+	return inputs
+}
+
+type cronSchedule struct {
+	minute, hour, dom, month, dow uint64 // Bit n set when value n matches
+	domAny, dowAny                bool
+}
+
+var cronMacros = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@nightly":  "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+func parseCronSpec(spec string) (*cronSchedule, error) {
+	if expanded, ok := cronMacros[strings.TrimSpace(spec)]; ok {
+		spec = expanded
+	}
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("cron: %q needs 5 fields, has %d", spec, len(fields))
+	}
+
+	s := &cronSchedule{domAny: fields[2] == "*", dowAny: fields[4] == "*"}
+	ranges := []struct {
+		bits     *uint64
+		min, max int
+	}{
+		{&s.minute, 0, 59},
+		{&s.hour, 0, 23},
+		{&s.dom, 1, 31},
+		{&s.month, 1, 12},
+		{&s.dow, 0, 7},
+	}
+	for i, r := range ranges {
+		bits, err := parseCronField(fields[i], r.min, r.max)
+		if err != nil {
+			return nil, fmt.Errorf("cron: %q: %w", spec, err)
+		}
+		*r.bits = bits
+	}
+	// Sunday may be written as 0 or 7
+	if s.dow&(1<<7) != 0 {
+		s.dow |= 1
+	}
+	return s, nil
+}
+
+// parseCronField handles "*", "n", "a-b" and "/step" on either, and comma
+// separated lists of those
+func parseCronField(field string, min, max int) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		rangePart, stepPart, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			n, err := strconv.Atoi(stepPart)
+			if err != nil || n <= 0 {
+				return 0, fmt.Errorf("bad step %q", part)
+			}
+			step = n
+		}
+
+		lo, hi := min, max
+		if rangePart != "*" {
+			first, last, isRange := strings.Cut(rangePart, "-")
+			var err error
+			if lo, err = strconv.Atoi(first); err != nil {
+				return 0, fmt.Errorf("bad value %q", part)
+			}
+			hi = lo
+			if isRange {
+				if hi, err = strconv.Atoi(last); err != nil {
+					return 0, fmt.Errorf("bad range %q", part)
+				}
+			} else if hasStep {
+				hi = max
+			}
+		}
+		if lo < min || hi > max || lo > hi {
+			return 0, fmt.Errorf("%q out of range %d-%d", part, min, max)
+		}
+		for v := lo; v <= hi; v += step {
+			bits |= 1 << v
+		}
+	}
+	return bits, nil
+}
+
+func (s *cronSchedule) matches(t time.Time) bool {
+	if s.minute&(1<<t.Minute()) == 0 || s.hour&(1<<t.Hour()) == 0 || s.month&(1<<int(t.Month())) == 0 {
+		return false
+	}
+	dom := s.dom&(1<<t.Day()) != 0
+	dow := s.dow&(1<<int(t.Weekday())) != 0
+	// As in cron, a restricted day-of-month and day-of-week match either
+	switch {
+	case s.domAny && s.dowAny:
+		return true
+	case s.domAny:
+		return dow
+	case s.dowAny:
+		return dom
+	default:
+		return dom || dow
+	}
+}
+
+// firesBetween reports whether any whole minute in (prev, now] matches
+func (s *cronSchedule) firesBetween(prev, now time.Time) bool {
+	prev, now = prev.UTC(), now.UTC()
+	for t := prev.Truncate(time.Minute).Add(time.Minute); !t.After(now); t = t.Add(time.Minute) {
+		if s.matches(t) {
+			return true
+		}
+	}
+	return false
+}
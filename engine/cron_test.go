@@ -0,0 +1,119 @@
+package engine
+
+import (
+	"testing"
+	"time"
+)
+
+func bitsOf(values ...int) uint64 {
+	var bits uint64
+	for _, v := range values {
+		bits |= 1 << v
+	}
+	return bits
+}
+
+func TestParseCronField(t *testing.T) {
+	tests := []struct {
+		field    string
+		min, max int
+		want     uint64
+		wantErr  bool
+	}{
+		{"*", 0, 5, bitsOf(0, 1, 2, 3, 4, 5), false},
+		{"3", 0, 59, bitsOf(3), false},
+		{"1-3", 0, 59, bitsOf(1, 2, 3), false},
+		{"*/15", 0, 59, bitsOf(0, 15, 30, 45), false},
+		{"10-20/5", 0, 59, bitsOf(10, 15, 20), false},
+		{"50/4", 0, 59, bitsOf(50, 54, 58), false},
+		{"1,5,9-10", 0, 59, bitsOf(1, 5, 9, 10), false},
+		{"0", 1, 31, 0, true},
+		{"60", 0, 59, 0, true},
+		{"5-1", 0, 59, 0, true},
+		{"*/0", 0, 59, 0, true},
+		{"*/x", 0, 59, 0, true},
+		{"a", 0, 59, 0, true},
+		{"1-b", 0, 59, 0, true},
+		{"", 0, 59, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.field, func(t *testing.T) {
+			got, err := parseCronField(tt.field, tt.min, tt.max)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseCronField(%q) error %v, want error %t", tt.field, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("parseCronField(%q) = %b, want %b", tt.field, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCronScheduleMatches(t *testing.T) {
+	// 2024-01-07 is a Sunday
+	at := func(day, hour, minute int) time.Time {
+		return time.Date(2024, time.January, day, hour, minute, 0, 0, time.UTC)
+	}
+	tests := []struct {
+		spec  string
+		times map[time.Time]bool
+	}{
+		{"@hourly", map[time.Time]bool{at(7, 3, 0): true, at(7, 3, 1): false}},
+		{"@daily", map[time.Time]bool{at(8, 0, 0): true, at(8, 1, 0): false}},
+		{"@weekly", map[time.Time]bool{at(7, 0, 0): true, at(8, 0, 0): false}},
+		{"0 0 * * 7", map[time.Time]bool{at(7, 0, 0): true, at(14, 0, 0): true, at(13, 0, 0): false}},
+		{"30 9 * * 1-5", map[time.Time]bool{at(8, 9, 30): true, at(12, 9, 30): true, at(13, 9, 30): false}},
+		// Restricted day-of-month and day-of-week match either
+		{"0 12 1 * 0", map[time.Time]bool{at(1, 12, 0): true, at(7, 12, 0): true, at(2, 12, 0): false}},
+		{"*/20 * 2 1 *", map[time.Time]bool{at(2, 5, 40): true, at(2, 5, 41): false, at(3, 5, 40): false}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			s, err := parseCronSpec(tt.spec)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for when, want := range tt.times {
+				if got := s.matches(when); got != want {
+					t.Errorf("matches(%s) = %t, want %t", when.Format(time.RFC1123), got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestParseCronSpecErrors(t *testing.T) {
+	for _, spec := range []string{"", "* * * *", "* * * * * *", "@sometimes", "61 * * * *", "* 24 * * *", "* * 32 * *", "* * * 13 *", "* * * * 8"} {
+		if _, err := parseCronSpec(spec); err == nil {
+			t.Errorf("parseCronSpec(%q) accepted", spec)
+		}
+	}
+}
+
+func TestCronFiresBetween(t *testing.T) {
+	base := time.Date(2024, time.January, 7, 10, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name      string
+		spec      string
+		prev, now time.Time
+		want      bool
+	}{
+		{"minute reached", "5 * * * *", base.Add(4 * time.Minute), base.Add(5 * time.Minute), true},
+		{"start excluded", "5 * * * *", base.Add(5 * time.Minute), base.Add(5*time.Minute + 59*time.Second), false},
+		{"within a tick", "* * * * *", base.Add(10 * time.Second), base.Add(20 * time.Second), false},
+		{"jump over it", "5 * * * *", base, base.Add(time.Hour), true},
+		{"not yet", "5 * * * *", base, base.Add(4 * time.Minute), false},
+		{"other zone", "0 11 * * *", base.In(time.FixedZone("X", 3600)), base.Add(time.Hour).In(time.FixedZone("X", 3600)), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := parseCronSpec(tt.spec)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := s.firesBetween(tt.prev, tt.now); got != tt.want {
+				t.Errorf("firesBetween = %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
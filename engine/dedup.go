@@ -0,0 +1,81 @@
+package engine
+
+const defaultDedupWindow = 1024
+
+// Result is what the engine produced for one input
+type Result struct {
+	Seq       int      `json:"seq"` // Seq of the input record, 0 if it was never sequenced
+	Outputs   []string `json:"outputs"`
+	Duplicate bool     `json:"duplicate"` // Input repeated an earlier key and was not re-applied
+}
+
+type KeyResult struct {
+	Key    string `json:"key"`
+	Result Result `json:"result"`
+}
+
+// dedupKey scopes an idempotency key to the kind of source that sent it,
+// and to the user when there is one, so keys from a CLI and a web client
+// never swallow each other's inputs. Connection ids and remote addresses
+// change whenever a client reconnects, which is exactly when it retries, so
+// they are left out.
+func dedupKey(input Input) string {
+	scope := input.Source.Kind
+	if input.Source.User != "" {
+		scope += "@" + input.Source.User
+	}
+	return scope + "|" + input.Key
+}
+
+// dedupTable remembers the results of the most recent idempotency keys.
+// It is filled from the sequenced input stream only, so a replay rebuilds
+// exactly the same table.
+type dedupTable struct {
+	window  int
+	order   []string
+	results map[string]Result
+}
+
+func newDedupTable(window int) *dedupTable {
+	if window <= 0 {
+		window = defaultDedupWindow
+	}
+	return &dedupTable{
+		window:  window,
+		results: make(map[string]Result),
+	}
+}
+
+func (d *dedupTable) lookup(key string) (Result, bool) {
+	result, ok := d.results[key]
+	return result, ok
+}
+
+func (d *dedupTable) remember(key string, result Result) {
+	if _, ok := d.results[key]; ok {
+		return
+	}
+	if len(d.order) >= d.window {
+		delete(d.results, d.order[0])
+		d.order = d.order[1:]
+	}
+	d.order = append(d.order, key)
+	d.results[key] = result
+}
+
+// entries returns the remembered keys oldest first
+func (d *dedupTable) entries() []KeyResult {
+	entries := make([]KeyResult, 0, len(d.order))
+	for _, key := range d.order {
+		entries = append(entries, KeyResult{Key: key, Result: d.results[key]})
+	}
+	return entries
+}
+
+func (d *dedupTable) load(entries []KeyResult) {
+	d.order = nil
+	d.results = make(map[string]Result)
+	for _, entry := range entries {
+		d.remember(entry.Key, entry.Result)
+	}
+}
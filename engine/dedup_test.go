@@ -0,0 +1,77 @@
+package engine
+
+import "testing"
+
+func TestDedupKey(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b Input
+		same bool
+	}{
+		{"same source", Input{Key: "k", Source: Source{Kind: "ws", ID: "1"}}, Input{Key: "k", Source: Source{Kind: "ws", ID: "1"}}, true},
+		{"reconnected", Input{Key: "k", Source: Source{Kind: "ws", ID: "1"}}, Input{Key: "k", Source: Source{Kind: "ws", ID: "2"}}, true},
+		{"other remote port", Input{Key: "k", Source: Source{Kind: "http", ID: "10.0.0.1:50000"}}, Input{Key: "k", Source: Source{Kind: "http", ID: "10.0.0.1:50001"}}, true},
+		{"other kind", Input{Key: "k", Source: Source{Kind: "ws", ID: "1"}}, Input{Key: "k", Source: Source{Kind: "http", ID: "1"}}, false},
+		{"same user reconnected", Input{Key: "k", Source: Source{Kind: "ws", ID: "1", User: "bob"}}, Input{Key: "k", Source: Source{Kind: "ws", ID: "2", User: "bob"}}, true},
+		{"other user", Input{Key: "k", Source: Source{Kind: "ws", ID: "1", User: "bob"}}, Input{Key: "k", Source: Source{Kind: "ws", ID: "1", User: "amy"}}, false},
+		{"other key", Input{Key: "k", Source: Source{Kind: "ws", ID: "1"}}, Input{Key: "j", Source: Source{Kind: "ws", ID: "1"}}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if same := dedupKey(tt.a) == dedupKey(tt.b); same != tt.same {
+				t.Errorf("dedupKey(%+v) == dedupKey(%+v) is %t, want %t", tt.a, tt.b, same, tt.same)
+			}
+		})
+	}
+}
+
+func TestDedupTableWindow(t *testing.T) {
+	tests := []struct {
+		name     string
+		window   int
+		remember []string
+		want     map[string]bool
+	}{
+		{"within window", 3, []string{"a", "b", "c"}, map[string]bool{"a": true, "b": true, "c": true}},
+		{"oldest forgotten", 2, []string{"a", "b", "c"}, map[string]bool{"a": false, "b": true, "c": true}},
+		{"repeat keeps first result", 2, []string{"a", "a", "b"}, map[string]bool{"a": true, "b": true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := newDedupTable(tt.window)
+			for i, key := range tt.remember {
+				d.remember(key, Result{Seq: i + 1})
+			}
+			for key, want := range tt.want {
+				if _, ok := d.lookup(key); ok != want {
+					t.Errorf("lookup(%s) = %t, want %t", key, ok, want)
+				}
+			}
+			if result, ok := d.lookup(tt.remember[0]); ok && result.Seq != 1 {
+				t.Errorf("lookup(%s).Seq = %d, want 1", tt.remember[0], result.Seq)
+			}
+
+			loaded := newDedupTable(tt.window)
+			loaded.load(d.entries())
+			if len(loaded.entries()) != len(d.entries()) {
+				t.Errorf("reloaded %d entries, want %d", len(loaded.entries()), len(d.entries()))
+			}
+		})
+	}
+}
+
+func TestDuplicateInputsPerSource(t *testing.T) {
+	e := NewEngineWithSink(NewMemorySink())
+	e.SetReplayMode()
+
+	first := e.Apply(Input{Line: "ttt|new|", Key: "k", Source: Source{Kind: "ws", ID: "1"}})
+	repeat := e.Apply(Input{Line: "ttt|new|", Key: "k", Source: Source{Kind: "ws", ID: "1"}})
+	other := e.Apply(Input{Line: "ttt|new|", Key: "k", Source: Source{Kind: "http", ID: "1"}})
+
+	if first.Duplicate || other.Duplicate {
+		t.Errorf("first input of each source marked duplicate: %+v, %+v", first, other)
+	}
+	if !repeat.Duplicate || repeat.Seq != first.Seq {
+		t.Errorf("repeat = %+v, want the result of seq %d", repeat, first.Seq)
+	}
+}
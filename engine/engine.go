@@ -3,57 +3,53 @@ package engine
 import (
 	"fmt"
 	"github.com/ivorytoast/replay78/states"
-	"log"
 	"os"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
+// clockTopic carries the engine clock: each tick's payload is a UTC time in
+// nanoseconds since the epoch
+const clockTopic = "tick"
+
 type Application interface {
 	OnEvent(event []string)
 	Topics() []string
 }
 
-type InputGenerator interface {
-	Start(engine *Engine)
-}
-
-type IntervalGenerator struct {
-	InputFunc func() string
-	Interval  time.Duration
-}
-
-type ConnectionGenerator struct {
-	StartFunc func(engine *Engine)
-}
-
-func (g *IntervalGenerator) Start(e *Engine) {
-	go func() {
-		for {
-			input := g.InputFunc()
-			e.In(input)
-			time.Sleep(g.Interval)
-		}
-	}()
-	log.Printf("Started interval generator (interval: %v)", g.Interval)
-}
+type Engine struct {
+	sink           LogSink
+	sinkMu         sync.Mutex
+	seq            int
+	queue          *inputQueue
+	priorities     map[string]Priority
+	applications   map[string]Application
+	generators     []*generatorEntry
+	replayMode     bool
+	dispatchPaused bool
+	current        Input
+	outputs        []string // Outputs of the input being dispatched
+	dedup          *dedupTable
+	logFile        string
+	previousLog    string // Log of the previous run, if any
+	listeners      []func(line string)
+	listenersMu    sync.Mutex
+	sequencer      Sequencer
 
-func (g *ConnectionGenerator) Start(e *Engine) {
-	go func() {
-		log.Printf("Started connection generator")
-		g.StartFunc(e)
-	}()
-}
+	clock        time.Time // Time of the latest tick processed
+	clockHooks   []func(prev, now time.Time) []Input
+	clockHooksMu sync.Mutex
 
-type Engine struct {
-	file         *os.File
-	seq          int
-	queue        chan string
-	applications map[string]Application
-	generators   []InputGenerator
+	inputCount       int
+	snapshotInterval int
 
-	TicTacToeState *states.TicTacToeState
+	TicTacToeState *states.TicTacToeState            // Game of inputs without a partition
+	partitions     map[string]*states.TicTacToeState // Game of each partition, see PartitionState
+	game           *states.TicTacToeState            // Game of the input being dispatched
+	logLevel       atomic.Int32                      // Set by engine|loglevel, inheritLogLevel until then
 }
 
 func NewEngine() *Engine {
@@ -70,10 +66,15 @@ func NewEngine() *Engine {
 
 	// Create the next log file
 	nextLogFile := fmt.Sprintf("78-%d.log", highestNum+1)
-	return NewEngineWithLogFile(nextLogFile)
+	e := NewEngineWithLogFile(nextLogFile)
+	if highestNum > 0 {
+		e.previousLog = fmt.Sprintf("78-%d.log", highestNum)
+	}
+	return e
 }
 
 func NewEngineWithLogFile(logFileName string) *Engine {
+	previousLog := ""
 	if _, err := os.Stat(logFileName); err == nil {
 		// Extract base name and current number
 		base := strings.TrimSuffix(logFileName, ".log")
@@ -94,31 +95,54 @@ func NewEngineWithLogFile(logFileName string) *Engine {
 		for i := startNum; ; i++ {
 			rotated := fmt.Sprintf("%s-%d.log", base, i)
 			if _, err := os.Stat(rotated); os.IsNotExist(err) {
-				os.Rename(logFileName, rotated)
+				renameSegments(logFileName, rotated)
+				previousLog = rotated
 				break
 			}
 		}
 	}
-	f, _ := os.Create(logFileName)
+	var sink LogSink
+	if file, err := NewFileSink(logFileName); err == nil {
+		sink = file
+	} else {
+		logf(LogError, "Cannot create log file: %v", err)
+	}
+	e := newEngine(sink)
+	e.logFile = logFileName
+	e.previousLog = previousLog
+	return e
+}
+
+// NewEngineWithSink creates an engine that writes its log to sink rather
+// than a file of its own, e.g. a MemorySink in tests. Features that read the
+// log back from disk need LogFile and so a file; snapshots are skipped.
+func NewEngineWithSink(sink LogSink) *Engine {
+	return newEngine(sink)
+}
 
+func newEngine(sink LogSink) *Engine {
 	g := NewCustomInputGenerator(
 		func() string {
 			return "tick|tock|" + strconv.FormatInt(time.Now().UTC().UnixNano(), 10)
 		},
 		1*time.Second,
 	)
+	g.Name = "tick"
 
-	generators := make([]InputGenerator, 0)
-	generators = append(generators, g)
-
-	return &Engine{
-		file:           f,
-		queue:          make(chan string, 100),
+	e := &Engine{
+		sink:           sink,
+		queue:          newInputQueue(DefaultQueueConfig()),
+		priorities:     map[string]Priority{"tick": PriorityTick, engineTopic: PriorityAdmin},
 		applications:   make(map[string]Application),
-		generators:     generators,
+		dedup:          newDedupTable(defaultDedupWindow),
 		seq:            0,
 		TicTacToeState: states.NewTicTacToeState(),
+		partitions:     make(map[string]*states.TicTacToeState),
 	}
+	e.logLevel.Store(int32(inheritLogLevel))
+	e.queue.logf = e.logf
+	e.RegisterGenerator(g)
+	return e
 }
 
 func (e *Engine) RegisterApplication(app Application) {
@@ -127,27 +151,73 @@ func (e *Engine) RegisterApplication(app Application) {
 	}
 }
 
-func (e *Engine) RegisterGenerator(gen InputGenerator) {
-	e.generators = append(e.generators, gen)
+// SetQueueConfig replaces the input queue. Call it before Run.
+func (e *Engine) SetQueueConfig(config QueueConfig) {
+	ordered := e.queue.ordered
+	e.queue = newInputQueue(config)
+	e.queue.ordered = ordered
+	e.queue.logf = e.logf
 }
 
-func NewCustomInputGenerator(inputFunc func() string, interval time.Duration) *IntervalGenerator {
-	return &IntervalGenerator{
-		InputFunc: inputFunc,
-		Interval:  interval,
-	}
+// SetReplayMode makes the engine process inputs in exactly the order they
+// are submitted, ignoring priority lanes, and skips starting generators.
+// A recorded log already holds the merged interleaving, so replaying it
+// through the lanes again could reorder it. Call it before Run.
+func (e *Engine) SetReplayMode() {
+	e.replayMode = true
+	e.queue.ordered = true
 }
 
-func NewConnectionInputGenerator(startFunc func(engine *Engine)) *ConnectionGenerator {
-	return &ConnectionGenerator{
-		StartFunc: startFunc,
-	}
+// SetTopicPriority sets the priority used by OverloadDropLowest for a topic
+func (e *Engine) SetTopicPriority(topic string, priority Priority) {
+	e.priorities[topic] = priority
 }
 
+// TTT returns the game of the input being dispatched, or the game of
+// inputs without a partition between inputs
 func (e *Engine) TTT() *states.TicTacToeState {
+	if e.game != nil {
+		return e.game
+	}
 	return e.TicTacToeState
 }
 
+// PartitionState returns the game of a partition, e.g. a game id. Inputs
+// without a partition all share TicTacToeState, and a partition no input
+// has reached yet gets a new game that is not kept. Call it from the engine
+// loop or while the engine is idle.
+func (e *Engine) PartitionState(partition string) *states.TicTacToeState {
+	if partition == "" {
+		return e.TicTacToeState
+	}
+	if state, ok := e.partitions[partition]; ok {
+		return state
+	}
+	return states.NewTicTacToeState()
+}
+
+// partitionGame is PartitionState for dispatch, keeping a new game
+func (e *Engine) partitionGame(partition string) *states.TicTacToeState {
+	state, ok := e.partitions[partition]
+	if !ok {
+		state = states.NewTicTacToeState()
+		e.partitions[partition] = state
+	}
+	return state
+}
+
+// CurrentInput returns the input being dispatched, so applications can see
+// its source from inside OnEvent
+func (e *Engine) CurrentInput() Input {
+	return e.current
+}
+
+// Seq is the seq of the last record written. Call it from the engine loop
+// or on an engine that is not running.
+func (e *Engine) Seq() int {
+	return e.seq
+}
+
 func (e *Engine) nextSeq() int {
 	e.seq++
 	return e.seq
@@ -157,32 +227,371 @@ func (e *Engine) Run() {
 	go e.run()
 }
 
+// EndReplayMode switches a running replay engine over to live operation,
+// e.g. when a follower is promoted: lanes are honoured again and the
+// generators are started, all in whatever state the log left them.
+func (e *Engine) EndReplayMode() error {
+	e.queue.mu.Lock()
+	e.queue.ordered = false
+	e.queue.mu.Unlock()
+	return e.Inspect(func() {
+		e.replayMode = false
+		e.queue.setPaused(e.dispatchPaused)
+		e.startGenerators()
+	})
+}
+
 func (e *Engine) run() {
-	for _, gen := range e.generators {
-		gen.Start(e)
+	if !e.replayMode {
+		e.startGenerators()
 	}
-	for line := range e.queue {
-		parts, isValid := parseMsg(line)
-		if !isValid {
-			e.Out("Bad Input: " + line)
+	for {
+		item := e.queue.pop()
+		if item.inspect != nil {
+			item.inspect()
+			item.reply <- Result{}
 			continue
 		}
-		topic := parts[0]
-		action := parts[1]
-		payload := parts[2]
-		e.file.WriteString(fmt.Sprintf("%d|I|%s|%s|%s\n", e.nextSeq(), topic, action, payload))
-		if app, ok := e.applications[topic]; ok {
-			app.OnEvent(parts)
+		prev := e.clock
+		result := e.process(item.input)
+		if item.reply != nil {
+			item.reply <- result
+		}
+		if e.clock.After(prev) {
+			e.advanceClock(prev)
+		}
+	}
+}
+
+// Apply processes one input synchronously on the caller's goroutine, the
+// way the run loop would, and returns its result. It lets tools step through
+// a log one input at a time. Use it on an engine in replay mode that is not
+// running.
+func (e *Engine) Apply(input Input) Result {
+	prev := e.clock
+	result := e.process(input)
+	if e.clock.After(prev) {
+		e.advanceClock(prev)
+	}
+	return result
+}
+
+// Clock is the engine's notion of the current time: the timestamp carried
+// by the latest tick input, or the zero time before the first one. Unlike
+// the wall clock it reads the same when a log is replayed. Call it from the
+// engine loop, e.g. inside OnEvent.
+func (e *Engine) Clock() time.Time {
+	return e.clock
+}
+
+// onClock registers fn to run on the engine loop whenever a tick moves the
+// clock forward. The inputs it returns are processed straight after the tick.
+func (e *Engine) onClock(fn func(prev, now time.Time) []Input) {
+	e.clockHooksMu.Lock()
+	e.clockHooks = append(e.clockHooks, fn)
+	e.clockHooksMu.Unlock()
+}
+
+func (e *Engine) advanceClock(prev time.Time) {
+	e.clockHooksMu.Lock()
+	hooks := append([]func(prev, now time.Time) []Input(nil), e.clockHooks...)
+	e.clockHooksMu.Unlock()
+	for _, hook := range hooks {
+		for _, input := range hook(prev, e.clock) {
+			e.process(input)
+		}
+	}
+}
+
+func (e *Engine) process(input Input) Result {
+	e.outputs = nil
+	defer func() { e.outputs = nil }()
+
+	parts, isValid := parseMsg(input.Line)
+	if !isValid {
+		e.Out("Bad Input: " + input.Line)
+		return Result{Outputs: e.outputs}
+	}
+	topic := parts[0]
+	action := parts[1]
+	payload := parts[2]
+	kind := inputKind(input, e.topicPriority(topic))
+	seq := e.nextSeq()
+	e.write(fmt.Sprintf("%d|%s|%s|%s|%s", seq, kind, topic, action, payload))
+	e.logf(LogDebug, "Dispatching seq %d: %s", seq, input.Line)
+	if input.Source.Kind != "" {
+		if gen := e.findGenerator(input.Source.Kind, input.Source.ID); gen != nil {
+			gen.lastSeq = seq
+		}
+	}
+
+	// A repeated key is sequenced for the audit trail but never re-applied
+	if input.Key != "" {
+		if original, ok := e.dedup.lookup(dedupKey(input)); ok {
+			e.Out(fmt.Sprintf("Duplicate input ignored: key %s already applied at seq %d", input.Key, original.Seq))
+			original.Duplicate = true
+			return original
+		}
+	}
+
+	if topic == clockTopic {
+		if nanos, err := strconv.ParseInt(payload, 10, 64); err == nil {
+			if now := time.Unix(0, nanos).UTC(); now.After(e.clock) {
+				e.clock = now
+			}
+		}
+	}
+
+	e.current = input
+	if input.Partition != "" {
+		e.game = e.partitionGame(input.Partition)
+	}
+	if topic == engineTopic {
+		e.handleAdmin(action, payload)
+	} else if app, ok := e.applications[topic]; ok {
+		app.OnEvent(parts)
+	}
+	e.current = Input{}
+	e.game = nil
+
+	result := Result{Seq: seq, Outputs: e.outputs}
+	if input.Key != "" {
+		e.dedup.remember(dedupKey(input), result)
+	}
+
+	e.inputCount++
+	if e.snapshotInterval > 0 && e.inputCount%e.snapshotInterval == 0 {
+		if err := e.writeSnapshot(); err != nil {
+			e.logf(LogError, "Snapshot failed: %v", err)
 		}
 	}
+	return result
+}
+
+// In queues an input, waiting for space if the overload policy allows it
+func (e *Engine) In(line string) error {
+	return e.Submit(Input{Line: line})
+}
+
+// TryIn queues an input without ever blocking, returning ErrQueueFull if
+// the queue has no room for it
+func (e *Engine) TryIn(line string) error {
+	return e.TrySubmit(Input{Line: line})
+}
+
+// Submit is In for inputs that carry more than a line, such as an explicit lane
+func (e *Engine) Submit(input Input) error {
+	if e.sequencer != nil {
+		_, err := e.sequencer.Sequence(input, false)
+		return err
+	}
+	return e.queue.push(e.queued(input), true)
+}
+
+// TrySubmit is the non-blocking form of Submit
+func (e *Engine) TrySubmit(input Input) error {
+	if e.sequencer != nil {
+		_, err := e.sequencer.Sequence(input, false)
+		return err
+	}
+	return e.queue.push(e.queued(input), false)
+}
+
+// SubmitWait queues an input and waits until it has been processed. For a
+// duplicate idempotency key the original input's result is returned.
+func (e *Engine) SubmitWait(input Input) (Result, error) {
+	if e.sequencer != nil {
+		return e.sequencer.Sequence(input, true)
+	}
+	return e.deliver(input, true)
+}
+
+// Submitter accepts inputs for processing. An Engine is one, and so is a
+// runtime that spreads inputs over several engines.
+type Submitter interface {
+	Submit(input Input) error
+	TrySubmit(input Input) error
+	SubmitWait(input Input) (Result, error)
+	TrySubmitWait(input Input) (Result, error)
+}
+
+// Sequencer fixes the order of inputs before the engine sees them, e.g. by
+// committing them to a replicated log. Once committed, the sequencer hands
+// each input back through Deliver.
+type Sequencer interface {
+	// Sequence submits an input for ordering. With wait set it returns the
+	// engine's result once the input has been committed and processed.
+	Sequence(input Input, wait bool) (Result, error)
+}
+
+// SetSequencer routes Submit, TrySubmit and SubmitWait through s. Call it
+// before Run.
+func (e *Engine) SetSequencer(s Sequencer) {
+	e.sequencer = s
+}
+
+// TrySubmitWait is SubmitWait without waiting for queue space: it returns
+// ErrQueueFull straight away rather than stall the caller
+func (e *Engine) TrySubmitWait(input Input) (Result, error) {
+	if e.sequencer != nil {
+		return e.sequencer.Sequence(input, true)
+	}
+	return e.deliver(input, false)
+}
+
+// Deliver queues an input the sequencer has committed and waits for its
+// result. Committed inputs are never refused or evicted, whatever the
+// overload policy, or replicas could drop different inputs and diverge.
+func (e *Engine) Deliver(input Input) (Result, error) {
+	item := e.queued(input)
+	item.committed = true
+	return e.await(item, true)
 }
 
-func (e *Engine) In(line string) {
-	e.queue <- line
+func (e *Engine) deliver(input Input, wait bool) (Result, error) {
+	return e.await(e.queued(input), wait)
+}
+
+// await pushes item and waits for its result
+func (e *Engine) await(item queuedInput, wait bool) (Result, error) {
+	reply := make(chan Result, 1)
+	item.reply = reply
+	if err := e.queue.push(item, wait); err != nil {
+		return Result{}, err
+	}
+	result, ok := <-reply
+	if !ok {
+		return Result{}, ErrDropped
+	}
+	return result, nil
+}
+
+// Inspect runs fn on the engine loop between two inputs and waits for it,
+// giving a consistent view of state. fn must not submit inputs. It fails
+// without running fn if the queue refuses or drops the request, as the
+// overload policy decides.
+func (e *Engine) Inspect(fn func()) error {
+	done := make(chan Result, 1)
+	if err := e.queue.push(queuedInput{inspect: fn, priority: PriorityAdmin, reply: done}, true); err != nil {
+		return err
+	}
+	if _, ok := <-done; !ok {
+		return ErrDropped
+	}
+	return nil
+}
+
+// SetDedupWindow sets how many recent idempotency keys are remembered.
+// Call it before Run.
+func (e *Engine) SetDedupWindow(n int) {
+	e.dedup = newDedupTable(n)
+}
+
+func (e *Engine) queued(input Input) queuedInput {
+	priority := input.Priority
+	if priority == PriorityDefault {
+		topic, _, _ := strings.Cut(input.Line, "|")
+		priority = e.topicPriority(topic)
+	}
+	return queuedInput{input: input, priority: priority}
+}
+
+func (e *Engine) topicPriority(topic string) Priority {
+	if priority, ok := e.priorities[topic]; ok {
+		return priority
+	}
+	return PriorityPlayer
 }
 
 func (e *Engine) Out(line string) {
-	e.file.WriteString(fmt.Sprintf("%d|O|%s\n", e.nextSeq(), line))
+	e.write(fmt.Sprintf("%d|O|%s", e.nextSeq(), line))
+	e.outputs = append(e.outputs, line)
+}
+
+// write appends a record to the log and hands it to any listeners
+func (e *Engine) write(record string) {
+	e.sinkMu.Lock()
+	if e.sink != nil {
+		if err := e.sink.Write(record); err != nil {
+			e.logf(LogError, "Log write failed at %q: %v", record, err)
+		}
+	}
+	e.sinkMu.Unlock()
+	e.listenersMu.Lock()
+	defer e.listenersMu.Unlock()
+	for _, listener := range e.listeners {
+		listener(record)
+	}
+}
+
+// OnRecord registers a function called from the engine loop with every
+// record right after it is logged. It must not block.
+func (e *Engine) OnRecord(listener func(line string)) {
+	e.listenersMu.Lock()
+	e.listeners = append(e.listeners, listener)
+	e.listenersMu.Unlock()
+}
+
+// LogSink returns where the log is written, so it can be wrapped with
+// SetLogSink, e.g. in a MultiSink
+func (e *Engine) LogSink() LogSink {
+	e.sinkMu.Lock()
+	defer e.sinkMu.Unlock()
+	return e.sink
+}
+
+// SetLogSink replaces the log sink. It is safe while the engine runs: the
+// next record goes to the new sink. The old one is not closed.
+func (e *Engine) SetLogSink(sink LogSink) {
+	e.sinkMu.Lock()
+	e.sink = sink
+	e.sinkMu.Unlock()
+}
+
+// Close closes the log sink. Call it once nothing more will be submitted.
+func (e *Engine) Close() error {
+	e.sinkMu.Lock()
+	defer e.sinkMu.Unlock()
+	if e.sink == nil {
+		return nil
+	}
+	return e.sink.Close()
+}
+
+func (e *Engine) LogFile() string {
+	return e.logFile
+}
+
+// PreviousLogFile is the log written by the run before this one, or "" if
+// there was none. Generators use it to pick up where that run left off.
+func (e *Engine) PreviousLogFile() string {
+	return e.previousLog
+}
+
+// EarlierLogFiles lists the logs of the runs before this one, newest first,
+// starting with PreviousLogFile
+func (e *Engine) EarlierLogFiles() []string {
+	if e.previousLog == "" {
+		return nil
+	}
+	logs := []string{e.previousLog}
+	base := strings.TrimSuffix(e.previousLog, ".log")
+	dash := strings.LastIndex(base, "-")
+	if dash < 0 {
+		return logs
+	}
+	num, err := strconv.Atoi(base[dash+1:])
+	if err != nil {
+		return logs
+	}
+	for i := num - 1; i >= 1; i-- {
+		logFile := fmt.Sprintf("%s-%d.log", base[:dash], i)
+		if _, err := os.Stat(logFile); err != nil {
+			break
+		}
+		logs = append(logs, logFile)
+	}
+	return logs
 }
 
 func parseMsg(line string) ([]string, bool) {
@@ -0,0 +1,321 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+var (
+	ErrGeneratorPaused  = errors.New("generator paused")
+	ErrGeneratorStopped = errors.New("generator stopped")
+)
+
+// InputGenerator produces inputs on its own, e.g. on a timer. A live engine
+// starts every registered generator when it runs, a replay engine never
+// does, and either can be controlled through the engine topic.
+type InputGenerator interface {
+	Start(engine *Engine)
+	// Source is what the generator's inputs are attributed to, its ID
+	// doubles as the generator's name
+	Source() Source
+	Control() *GeneratorControl
+}
+
+type GeneratorState int
+
+const (
+	GeneratorRunning GeneratorState = iota
+	GeneratorPaused
+	GeneratorStopped
+)
+
+func (s GeneratorState) String() string {
+	switch s {
+	case GeneratorRunning:
+		return "running"
+	case GeneratorPaused:
+		return "paused"
+	default:
+		return "stopped"
+	}
+}
+
+func (s GeneratorState) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+func (s *GeneratorState) UnmarshalText(text []byte) error {
+	for _, state := range []GeneratorState{GeneratorRunning, GeneratorPaused, GeneratorStopped} {
+		if string(text) == state.String() {
+			*s = state
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown generator state %q", text)
+}
+
+// GeneratorStatus reports on one registered generator
+type GeneratorStatus struct {
+	Name    string         `json:"name"`
+	Kind    string         `json:"kind"`
+	State   GeneratorState `json:"state"`
+	LastSeq int            `json:"lastSeq"` // Seq of its latest input, 0 if none yet
+	Err     string         `json:"error,omitempty"`
+}
+
+// GeneratorControl is embedded in every generator. The engine moves it
+// between states and the generator's goroutine checks it before emitting.
+type GeneratorControl struct {
+	mu    sync.Mutex
+	state GeneratorState
+	run   int           // Bumped by every start, so an older goroutine knows to exit
+	wake  chan struct{} // Closed on every change
+	err   error
+}
+
+func (c *GeneratorControl) Control() *GeneratorControl {
+	return c
+}
+
+// start begins a new run in the given state and returns its token
+func (c *GeneratorControl) start(state GeneratorState) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.run++
+	c.err = nil
+	c.setLocked(state)
+	return c.run
+}
+
+func (c *GeneratorControl) set(state GeneratorState) {
+	c.mu.Lock()
+	c.setLocked(state)
+	c.mu.Unlock()
+}
+
+func (c *GeneratorControl) setLocked(state GeneratorState) {
+	c.state = state
+	if c.wake != nil {
+		close(c.wake)
+	}
+	c.wake = make(chan struct{})
+}
+
+// current is the token of the latest run, for a generator's Start to hold on to
+func (c *GeneratorControl) current() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.run
+}
+
+// active reports whether the generator may emit right now
+func (c *GeneratorControl) active() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	switch c.state {
+	case GeneratorPaused:
+		return ErrGeneratorPaused
+	case GeneratorStopped:
+		return ErrGeneratorStopped
+	}
+	return nil
+}
+
+// await blocks while the generator is paused and reports whether run should
+// carry on
+func (c *GeneratorControl) await(run int) bool {
+	for {
+		c.mu.Lock()
+		if c.run != run || c.state == GeneratorStopped {
+			c.mu.Unlock()
+			return false
+		}
+		if c.state == GeneratorRunning {
+			c.mu.Unlock()
+			return true
+		}
+		wake := c.wake
+		c.mu.Unlock()
+		<-wake
+	}
+}
+
+// sleep waits for d, cut short if run is stopped, then awaits as above
+func (c *GeneratorControl) sleep(run int, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	for {
+		c.mu.Lock()
+		alive := c.run == run && c.state != GeneratorStopped
+		wake := c.wake
+		c.mu.Unlock()
+		if !alive {
+			return false
+		}
+		select {
+		case <-timer.C:
+			return c.await(run)
+		case <-wake:
+		}
+	}
+}
+
+// fail records the generator's latest error for status reports
+func (c *GeneratorControl) fail(err error) {
+	c.mu.Lock()
+	c.err = err
+	c.mu.Unlock()
+}
+
+func (c *GeneratorControl) lastErr() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err
+}
+
+type IntervalGenerator struct {
+	GeneratorControl
+	Name      string
+	InputFunc func() string
+	Interval  time.Duration
+}
+
+type ConnectionGenerator struct {
+	GeneratorControl
+	Name      string
+	StartFunc func(engine *Engine)
+
+	engine *Engine
+}
+
+func NewCustomInputGenerator(inputFunc func() string, interval time.Duration) *IntervalGenerator {
+	return &IntervalGenerator{
+		InputFunc: inputFunc,
+		Interval:  interval,
+	}
+}
+
+func NewConnectionInputGenerator(startFunc func(engine *Engine)) *ConnectionGenerator {
+	return &ConnectionGenerator{
+		StartFunc: startFunc,
+	}
+}
+
+func (g *IntervalGenerator) Source() Source {
+	return Source{Kind: "gen", ID: g.Name}
+}
+
+func (g *IntervalGenerator) Start(e *Engine) {
+	run := g.current()
+	go func() {
+		for alive := g.await(run); alive; alive = g.sleep(run, g.Interval) {
+			input := Input{
+				Line:   g.InputFunc(),
+				Source: g.Source(),
+			}
+			if err := e.Submit(input); err != nil {
+				g.fail(err)
+				e.logf(LogWarn, "Interval generator input rejected: %v", err)
+			}
+		}
+	}()
+	e.logf(LogInfo, "Started interval generator (interval: %v)", g.Interval)
+}
+
+func (g *ConnectionGenerator) Source() Source {
+	return Source{Kind: "conn", ID: g.Name}
+}
+
+// Start runs StartFunc, which should return once In reports
+// ErrGeneratorStopped so that a later start does not run it twice
+func (g *ConnectionGenerator) Start(e *Engine) {
+	g.engine = e
+	go func() {
+		e.logf(LogInfo, "Started connection generator")
+		g.StartFunc(e)
+	}()
+}
+
+// In submits a line attributed to this connection. StartFunc should use it
+// rather than calling the engine directly so the log records the source.
+// While the generator is paused or stopped the line is refused.
+func (g *ConnectionGenerator) In(line string) error {
+	if err := g.active(); err != nil {
+		return err
+	}
+	return g.engine.Submit(Input{
+		Line:   line,
+		Source: g.Source(),
+	})
+}
+
+// generatorEntry is the engine's view of a registered generator. Its state
+// only changes on the engine loop, through engine topic commands, so it
+// reads the same when a log is replayed.
+type generatorEntry struct {
+	gen     InputGenerator
+	state   GeneratorState
+	lastSeq int
+}
+
+func (e *Engine) RegisterGenerator(gen InputGenerator) {
+	e.generators = append(e.generators, &generatorEntry{gen: gen})
+}
+
+// DisableGenerator keeps a registered generator from starting when the
+// engine runs, e.g. because its inputs are fed in from elsewhere. An engine
+// command can still start it. Call it before Run.
+func (e *Engine) DisableGenerator(name string) {
+	if entry := e.findGenerator("", name); entry != nil {
+		entry.state = GeneratorStopped
+	}
+}
+
+func (e *Engine) startGenerators() {
+	for _, entry := range e.generators {
+		if entry.state != GeneratorStopped {
+			e.launchGenerator(entry)
+		}
+	}
+}
+
+func (e *Engine) launchGenerator(entry *generatorEntry) {
+	entry.gen.Control().start(entry.state)
+	entry.gen.Start(e)
+}
+
+// findGenerator looks a generator up by name, or by the source its inputs
+// carry when kind is set
+func (e *Engine) findGenerator(kind, name string) *generatorEntry {
+	for _, entry := range e.generators {
+		source := entry.gen.Source()
+		if source.ID == name && (kind == "" || source.Kind == kind) {
+			return entry
+		}
+	}
+	return nil
+}
+
+// Generators reports on every registered generator. It waits for the engine
+// loop, so do not call it from inside OnEvent.
+func (e *Engine) Generators() ([]GeneratorStatus, error) {
+	var statuses []GeneratorStatus
+	err := e.Inspect(func() {
+		for _, entry := range e.generators {
+			source := entry.gen.Source()
+			status := GeneratorStatus{
+				Name:    source.ID,
+				Kind:    source.Kind,
+				State:   entry.state,
+				LastSeq: entry.lastSeq,
+			}
+			if err := entry.gen.Control().lastErr(); err != nil {
+				status.Err = err.Error()
+			}
+			statuses = append(statuses, status)
+		}
+	})
+	return statuses, err
+}
@@ -0,0 +1,162 @@
+// Package ingest accepts engine inputs over plain TCP or Unix sockets, one
+// "topic|action|payload" line at a time. Every line is answered with an
+// acknowledgement once the engine has processed it:
+//
+//	OK <seq>          input applied at seq, followed by its outputs
+//	DUP <seq>         repeated idempotency key, followed by the original outputs
+//	ERR <message>     input rejected, no outputs follow
+//
+// Outputs are sent as "OUT <text>" lines and every reply ends with "END".
+// Three commands change how later lines on the same connection are handled:
+//
+//	USER <name>             attribute later inputs to name
+//	GAME <id>               send later inputs to game id, see engine.Input.Partition
+//	KEY <key> <input line>  submit one input with an idempotency key
+//
+// Connections are not authenticated, so engine admin commands (the
+// "engine" topic) are refused with ERR rather than passed on.
+package ingest
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/ivorytoast/replay78/engine"
+)
+
+type Server struct {
+	engine engine.Submitter // An engine, or a runtime spreading games over several
+
+	mu        sync.Mutex
+	listeners []net.Listener
+	nextID    int
+}
+
+func NewServer(e engine.Submitter) *Server {
+	return &Server{engine: e}
+}
+
+func (s *Server) ListenTCP(addr string) (net.Addr, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	s.serve(listener, "tcp")
+	return listener.Addr(), nil
+}
+
+// ListenUnix serves on a Unix socket, replacing a stale socket file left
+// behind by an earlier run. Anything else already at path is left alone.
+func (s *Server) ListenUnix(path string) error {
+	if info, err := os.Lstat(path); err == nil {
+		if info.Mode()&os.ModeSocket == 0 {
+			return fmt.Errorf("ingest: %s exists and is not a socket", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+	}
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return err
+	}
+	s.serve(listener, "unix")
+	return nil
+}
+
+func (s *Server) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var firstErr error
+	for _, listener := range s.listeners {
+		if err := listener.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	s.listeners = nil
+	return firstErr
+}
+
+func (s *Server) serve(listener net.Listener, kind string) {
+	s.mu.Lock()
+	s.listeners = append(s.listeners, listener)
+	s.mu.Unlock()
+	log.Printf("Accepting engine inputs on %s %s", kind, listener.Addr())
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			s.mu.Lock()
+			s.nextID++
+			source := engine.Source{Kind: kind, ID: strconv.Itoa(s.nextID)}
+			s.mu.Unlock()
+			go s.handle(conn, source)
+		}
+	}()
+}
+
+func (s *Server) handle(conn net.Conn, source engine.Source) {
+	defer conn.Close()
+
+	scanner := bufio.NewScanner(conn)
+	writer := bufio.NewWriter(conn)
+	partition := ""
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		if user, ok := strings.CutPrefix(line, "USER "); ok {
+			source.User = strings.TrimSpace(user)
+			fmt.Fprintf(writer, "OK\nEND\n")
+		} else if game, ok := strings.CutPrefix(line, "GAME "); ok {
+			partition = strings.TrimSpace(game)
+			fmt.Fprintf(writer, "OK\nEND\n")
+		} else {
+			input := engine.Input{Line: line, Source: source, Partition: partition}
+			if rest, ok := strings.CutPrefix(line, "KEY "); ok {
+				input.Key, input.Line, _ = strings.Cut(rest, " ")
+			}
+			if engine.IsEngineCommand(input.Line) {
+				fmt.Fprintf(writer, "ERR engine commands are not accepted here\nEND\n")
+			} else {
+				s.submit(writer, input)
+			}
+		}
+
+		if err := writer.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+func (s *Server) submit(writer *bufio.Writer, input engine.Input) {
+	result, err := s.engine.SubmitWait(input)
+	switch {
+	case err != nil:
+		fmt.Fprintf(writer, "ERR %v\n", err)
+	case result.Seq == 0:
+		// Never sequenced, the only output explains why
+		fmt.Fprintf(writer, "ERR %s\n", strings.Join(result.Outputs, "; "))
+	case result.Duplicate:
+		fmt.Fprintf(writer, "DUP %d\n", result.Seq)
+	default:
+		fmt.Fprintf(writer, "OK %d\n", result.Seq)
+	}
+	if err == nil && result.Seq != 0 {
+		for _, output := range result.Outputs {
+			fmt.Fprintf(writer, "OUT %s\n", output)
+		}
+	}
+	fmt.Fprintf(writer, "END\n")
+}
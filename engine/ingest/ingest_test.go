@@ -0,0 +1,177 @@
+package ingest
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ivorytoast/replay78/engine"
+)
+
+func newTestServer(t *testing.T) *Server {
+	t.Helper()
+	e := engine.NewEngineWithSink(engine.NewMemorySink())
+	e.DisableGenerator("tick")
+	e.Run()
+	s := NewServer(e)
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func TestListenUnixExistingPath(t *testing.T) {
+	tests := []struct {
+		name    string
+		prepare func(path string) error
+		wantErr bool
+	}{
+		{"nothing there", func(string) error { return nil }, false},
+		{"stale socket", func(path string) error {
+			listener, err := net.Listen("unix", path)
+			if err != nil {
+				return err
+			}
+			// Closing a unix listener removes its file, so leave it behind
+			listener.(*net.UnixListener).SetUnlinkOnClose(false)
+			return listener.Close()
+		}, false},
+		{"regular file", func(path string) error { return os.WriteFile(path, []byte("keep me"), 0644) }, true},
+		{"directory", func(path string) error { return os.Mkdir(path, 0755) }, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "in.sock")
+			if err := tt.prepare(path); err != nil {
+				t.Fatal(err)
+			}
+			before, _ := os.Lstat(path)
+
+			err := newTestServer(t).ListenUnix(path)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ListenUnix = %v, want error %t", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				if after, _ := os.Lstat(path); after == nil || !os.SameFile(before, after) {
+					t.Errorf("%s was replaced", path)
+				}
+			}
+		})
+	}
+}
+
+// dial connects to the server and returns a function sending one line and
+// returning the reply
+func dial(t *testing.T, addr net.Addr) func(line string) []string {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	reader := bufio.NewReader(conn)
+	return func(line string) []string {
+		t.Helper()
+		fmt.Fprintln(conn, line)
+		var reply []string
+		for {
+			got, err := reader.ReadString('\n')
+			if err != nil {
+				t.Fatalf("%s: %v", line, err)
+			}
+			got = strings.TrimSpace(got)
+			if got == "END" {
+				return reply
+			}
+			reply = append(reply, got)
+		}
+	}
+}
+
+func TestConnectionCommands(t *testing.T) {
+	s := newTestServer(t)
+	addr, err := s.ListenTCP("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	send := dial(t, addr)
+
+	tests := []struct {
+		send string
+		want string // First line of the reply
+	}{
+		{"USER bob", "OK"},
+		{"GAME g1", "OK"},
+		{"ttt|new|", "OK 1"},
+		{"KEY k1 ttt|show|", "OK 2"},
+		{"KEY k1 ttt|show|", "DUP 2"},
+		{"garbage", "ERR Bad Input: garbage"},
+	}
+	for _, tt := range tests {
+		if reply := send(tt.send); len(reply) == 0 || reply[0] != tt.want {
+			t.Errorf("%s: reply %q, want %s first", tt.send, reply, tt.want)
+		}
+	}
+}
+
+func TestEngineCommandsRefused(t *testing.T) {
+	s := newTestServer(t)
+	addr, err := s.ListenTCP("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	send := dial(t, addr)
+
+	tests := []struct {
+		send string
+		want string
+	}{
+		{"engine|pause|", "ERR engine commands are not accepted here"},
+		{" engine |snapshot|", "ERR engine commands are not accepted here"},
+		{"KEY k1 engine|rotate|", "ERR engine commands are not accepted here"},
+		{"ttt|new|", "OK 1"}, // Nothing above was sequenced or paused dispatch
+	}
+	for _, tt := range tests {
+		if reply := send(tt.send); len(reply) == 0 || reply[0] != tt.want {
+			t.Errorf("%s: reply %q, want %s first", tt.send, reply, tt.want)
+		}
+	}
+}
+
+func TestKeyRetriedOnNewConnection(t *testing.T) {
+	tests := []struct {
+		name        string
+		first, next []string // Lines sent on each connection before the keyed input
+		want        string
+	}{
+		{"anonymous", nil, nil, "DUP 1"},
+		{"same user", []string{"USER bob"}, []string{"USER bob"}, "DUP 1"},
+		{"other user", []string{"USER bob"}, []string{"USER amy"}, "OK 2"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t)
+			addr, err := s.ListenTCP("127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			first := dial(t, addr)
+			for _, line := range tt.first {
+				first(line)
+			}
+			if reply := first("KEY k1 ttt|new|"); len(reply) == 0 || reply[0] != "OK 1" {
+				t.Fatalf("first attempt: reply %q", reply)
+			}
+
+			retry := dial(t, addr)
+			for _, line := range tt.next {
+				retry(line)
+			}
+			if reply := retry("KEY k1 ttt|new|"); len(reply) == 0 || reply[0] != tt.want {
+				t.Errorf("retry: reply %q, want %s first", reply, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,444 @@
+// Package logdiff compares two logs of the same inputs, e.g. a regression
+// baseline and its replay. Records are matched without their seqs and
+// aligned input by input, so an extra output line shows up as one insertion
+// rather than as every later record differing by its renumbered seq.
+//
+//	report, err := logdiff.Compare("baseline.log", "replay.log", 3)
+//	...
+//	if !report.Equal {
+//		report.WriteUnified(os.Stdout)
+//	}
+package logdiff
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/ivorytoast/replay78/engine/logreader"
+)
+
+// Line operations
+const (
+	Equal  = "equal"
+	Delete = "delete" // Only in the original
+	Insert = "insert" // Only in the replay
+)
+
+// Line is one record of the diff, without its seq
+type Line struct {
+	Op          string `json:"op"`
+	Text        string `json:"text"`
+	OriginalSeq int    `json:"originalSeq,omitempty"`
+	ReplaySeq   int    `json:"replaySeq,omitempty"`
+}
+
+// Hunk is a run of changes with the context lines around it. Starts and
+// counts are in records, which are numbered by seq.
+type Hunk struct {
+	OriginalStart int    `json:"originalStart"`
+	OriginalLines int    `json:"originalLines"`
+	ReplayStart   int    `json:"replayStart"`
+	ReplayLines   int    `json:"replayLines"`
+	Input         string `json:"input"` // Input whose records the first change belongs to
+	Lines         []Line `json:"lines"`
+}
+
+// Report is the outcome of comparing two logs
+type Report struct {
+	Original string `json:"original"`
+	Replay   string `json:"replay"`
+	Equal    bool   `json:"equal"`
+	Inserted int    `json:"inserted"`
+	Deleted  int    `json:"deleted"`
+
+	// DivergenceSeq is the seq in the original where the logs first
+	// differ, 0 when they are equal
+	DivergenceSeq int    `json:"divergenceSeq,omitempty"`
+	Hunks         []Hunk `json:"hunks,omitempty"`
+}
+
+// Compare diffs the logs at original and replay, keeping context unchanged
+// records around each change
+func Compare(original, replay string, context int) (*Report, error) {
+	originalSteps, err := logreader.ReadSteps(original)
+	if err != nil {
+		return nil, err
+	}
+	replaySteps, err := logreader.ReadSteps(replay)
+	if err != nil {
+		return nil, err
+	}
+	return compareSteps(original, replay, originalSteps, replaySteps, context), nil
+}
+
+// CompareRecords is Compare for a replay held in memory, e.g. by an
+// engine.MemorySink. replay is the name the report gives it.
+func CompareRecords(original, replay string, records []string, context int) (*Report, error) {
+	originalSteps, err := logreader.ReadSteps(original)
+	if err != nil {
+		return nil, err
+	}
+	parsed := make([]logreader.Record, 0, len(records))
+	for _, line := range records {
+		if record, err := logreader.Parse(line); err == nil {
+			parsed = append(parsed, record)
+		}
+	}
+	return compareSteps(original, replay, originalSteps, logreader.SplitSteps(parsed), context), nil
+}
+
+func compareSteps(original, replay string, originalSteps, replaySteps []logreader.Step, context int) *Report {
+	lines, inputs := align(originalSteps, replaySteps)
+	report := &Report{Original: original, Replay: replay, Equal: true}
+	nextOriginal := 1
+	for _, line := range lines {
+		switch line.Op {
+		case Delete:
+			report.Deleted++
+		case Insert:
+			report.Inserted++
+		}
+		if line.Op != Equal && report.Equal {
+			report.Equal = false
+			report.DivergenceSeq = nextOriginal
+			if line.Op == Delete {
+				report.DivergenceSeq = line.OriginalSeq
+			}
+		}
+		if line.OriginalSeq != 0 {
+			nextOriginal = line.OriginalSeq + 1
+		}
+	}
+	report.Hunks = hunks(lines, inputs, context)
+	return report
+}
+
+// align matches the steps of both logs by input, then the records within
+// each matched step. inputs holds the input line each diff line belongs to.
+func align(original, replay []logreader.Step) (lines []Line, inputs []string) {
+	add := func(input string, line Line) {
+		lines = append(lines, line)
+		inputs = append(inputs, input)
+	}
+	deleteStep := func(step logreader.Step) {
+		for i, record := range step.Records {
+			add(step.Input.Line, Line{Op: Delete, Text: record, OriginalSeq: step.Seq + i})
+		}
+	}
+	insertStep := func(step logreader.Step) {
+		for i, record := range step.Records {
+			add(step.Input.Line, Line{Op: Insert, Text: record, ReplaySeq: step.Seq + i})
+		}
+	}
+
+	originalInputs := make([]string, len(original))
+	for i, step := range original {
+		originalInputs[i] = step.Input.Line
+	}
+	replayInputs := make([]string, len(replay))
+	for i, step := range replay {
+		replayInputs[i] = step.Input.Line
+	}
+
+	for _, step := range diff(originalInputs, replayInputs) {
+		switch step.op {
+		case Delete:
+			deleteStep(original[step.a])
+		case Insert:
+			insertStep(replay[step.b])
+		case Equal:
+			o, r := original[step.a], replay[step.b]
+			for _, record := range diff(o.Records, r.Records) {
+				line := Line{Op: record.op}
+				if record.op != Insert {
+					line.Text = o.Records[record.a]
+					line.OriginalSeq = o.Seq + record.a
+				}
+				if record.op != Delete {
+					line.Text = r.Records[record.b]
+					line.ReplaySeq = r.Seq + record.b
+				}
+				add(o.Input.Line, line)
+			}
+		}
+	}
+	return lines, inputs
+}
+
+// edit is one element of the shortest edit script between a and b, with
+// the indexes it refers to
+type edit struct {
+	op   string
+	a, b int
+}
+
+// diff returns the shortest edit script from a to b, using the linear
+// space form of Myers' algorithm: the middle of an optimal path is found by
+// searching from both ends at once, and the halves either side of it are
+// diffed in turn. Memory stays proportional to len(a)+len(b) however far
+// apart two logs are.
+func diff(a, b []string) []edit {
+	d := &differ{a: a, b: b}
+	d.compare(0, len(a), 0, len(b))
+	return d.edits
+}
+
+type differ struct {
+	a, b  []string
+	edits []edit
+}
+
+func (d *differ) add(op string, i, j int) {
+	d.edits = append(d.edits, edit{op, i, j})
+}
+
+// compare appends the edits from a[aLo:aHi] to b[bLo:bHi]. Common prefixes
+// and suffixes are matched up front, which in a replay of the same inputs
+// is nearly everything.
+func (d *differ) compare(aLo, aHi, bLo, bHi int) {
+	for aLo < aHi && bLo < bHi && d.a[aLo] == d.b[bLo] {
+		d.add(Equal, aLo, bLo)
+		aLo++
+		bLo++
+	}
+	suffix := 0
+	for aLo < aHi-suffix && bLo < bHi-suffix && d.a[aHi-1-suffix] == d.b[bHi-1-suffix] {
+		suffix++
+	}
+	aHi -= suffix
+	bHi -= suffix
+
+	switch {
+	case aLo == aHi || bLo == bHi:
+		d.replace(aLo, aHi, bLo, bHi)
+	case aHi-aLo == 1 || bHi-bLo == 1:
+		d.single(aLo, aHi, bLo, bHi)
+	default:
+		x, y := d.middle(aLo, aHi, bLo, bHi)
+		if (x == aLo && y == bLo) || (x == aHi && y == bHi) {
+			d.replace(aLo, aHi, bLo, bHi)
+		} else {
+			d.compare(aLo, x, bLo, y)
+			d.compare(x, aHi, y, bHi)
+		}
+	}
+
+	for i := 0; i < suffix; i++ {
+		d.add(Equal, aHi+i, bHi+i)
+	}
+}
+
+// replace deletes all of a[aLo:aHi] and inserts all of b[bLo:bHi]
+func (d *differ) replace(aLo, aHi, bLo, bHi int) {
+	for i := aLo; i < aHi; i++ {
+		d.add(Delete, i, bLo)
+	}
+	for j := bLo; j < bHi; j++ {
+		d.add(Insert, aHi, j)
+	}
+}
+
+// single handles a side of one record, which is either kept at its first
+// match on the other side or replaced
+func (d *differ) single(aLo, aHi, bLo, bHi int) {
+	if aHi-aLo == 1 {
+		for j := bLo; j < bHi; j++ {
+			if d.a[aLo] == d.b[j] {
+				d.replace(aLo, aLo, bLo, j)
+				d.add(Equal, aLo, j)
+				d.replace(aHi, aHi, j+1, bHi)
+				return
+			}
+		}
+	} else {
+		for i := aLo; i < aHi; i++ {
+			if d.a[i] == d.b[bLo] {
+				d.replace(aLo, i, bLo, bLo)
+				d.add(Equal, i, bLo)
+				d.replace(i+1, aHi, bHi, bHi)
+				return
+			}
+		}
+	}
+	d.replace(aLo, aHi, bLo, bHi)
+}
+
+// middle returns a point on a shortest edit path from a[aLo:aHi] to
+// b[bLo:bHi] where the forward and backward searches meet. forward[k] and
+// backward[k] hold the furthest x reached on diagonal k = x-y, counted from
+// the start and from the end respectively.
+func (d *differ) middle(aLo, aHi, bLo, bHi int) (int, int) {
+	n, m := aHi-aLo, bHi-bLo
+	maxD := (n + m + 1) / 2
+	offset := maxD + 1
+	forward := make([]int, 2*offset+1)
+	backward := make([]int, 2*offset+1)
+	for i := range forward {
+		forward[i], backward[i] = -1, -1
+	}
+	forward[offset+1], backward[offset+1] = 0, 0
+	delta := n - m
+	odd := delta%2 != 0
+
+	// Diagonals that ran off an edge are not searched again
+	fStart, fEnd, bStart, bEnd := 0, 0, 0, 0
+	for step := 0; step < maxD; step++ {
+		for k := -step + fStart; k <= step-fEnd; k += 2 {
+			var x int
+			if k == -step || (k != step && forward[offset+k-1] < forward[offset+k+1]) {
+				x = forward[offset+k+1]
+			} else {
+				x = forward[offset+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && d.a[aLo+x] == d.b[bLo+y] {
+				x++
+				y++
+			}
+			forward[offset+k] = x
+			switch {
+			case x > n:
+				fEnd += 2
+			case y > m:
+				fStart += 2
+			case odd:
+				if back := offset + delta - k; back >= 0 && back < len(backward) && backward[back] != -1 && x >= n-backward[back] {
+					return aLo + x, bLo + y
+				}
+			}
+		}
+
+		for k := -step + bStart; k <= step-bEnd; k += 2 {
+			var x int
+			if k == -step || (k != step && backward[offset+k-1] < backward[offset+k+1]) {
+				x = backward[offset+k+1]
+			} else {
+				x = backward[offset+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && d.a[aHi-1-x] == d.b[bHi-1-y] {
+				x++
+				y++
+			}
+			backward[offset+k] = x
+			switch {
+			case x > n:
+				bEnd += 2
+			case y > m:
+				bStart += 2
+			case !odd:
+				if fwd := offset + delta - k; fwd >= 0 && fwd < len(forward) && forward[fwd] != -1 {
+					fx := forward[fwd]
+					if fx >= n-x {
+						return aLo + fx, bLo + fx - (delta - k)
+					}
+				}
+			}
+		}
+	}
+	// No path meets in the middle: nothing in common
+	return aLo, bLo
+}
+
+// hunks groups the changed lines with up to context equal lines on either
+// side, merging groups whose context would overlap
+func hunks(lines []Line, inputs []string, context int) []Hunk {
+	var result []Hunk
+	for start := 0; start < len(lines); {
+		first := start
+		for first < len(lines) && lines[first].Op == Equal {
+			first++
+		}
+		if first == len(lines) {
+			break
+		}
+
+		// Extend past changes separated by at most 2*context equal lines
+		end := first
+		for end < len(lines) {
+			if lines[end].Op != Equal {
+				end++
+				continue
+			}
+			next := end
+			for next < len(lines) && lines[next].Op == Equal {
+				next++
+			}
+			if next == len(lines) || next-end > 2*context {
+				break
+			}
+			end = next
+		}
+
+		from := max(first-context, start)
+		to := min(end+context, len(lines))
+		result = append(result, newHunk(lines, from, to, inputs[first]))
+		start = to
+	}
+	return result
+}
+
+func newHunk(lines []Line, from, to int, input string) Hunk {
+	h := Hunk{Input: input, Lines: lines[from:to]}
+	// Where a side has no lines in the hunk, start at the record before it
+	// as unified diffs do
+	for _, line := range lines[:from] {
+		if line.OriginalSeq != 0 {
+			h.OriginalStart = line.OriginalSeq
+		}
+		if line.ReplaySeq != 0 {
+			h.ReplayStart = line.ReplaySeq
+		}
+	}
+	originalStart, replayStart := 0, 0
+	for _, line := range h.Lines {
+		if line.OriginalSeq != 0 {
+			if originalStart == 0 {
+				originalStart = line.OriginalSeq
+			}
+			h.OriginalLines++
+		}
+		if line.ReplaySeq != 0 {
+			if replayStart == 0 {
+				replayStart = line.ReplaySeq
+			}
+			h.ReplayLines++
+		}
+	}
+	if originalStart != 0 {
+		h.OriginalStart = originalStart
+	}
+	if replayStart != 0 {
+		h.ReplayStart = replayStart
+	}
+	return h
+}
+
+// WriteUnified writes the report as a unified diff, with each hunk header
+// naming the input the first change belongs to
+func (r *Report) WriteUnified(w io.Writer) error {
+	if r.Equal {
+		return nil
+	}
+	if _, err := fmt.Fprintf(w, "--- %s\n+++ %s\n", r.Original, r.Replay); err != nil {
+		return err
+	}
+	for _, h := range r.Hunks {
+		if _, err := fmt.Fprintf(w, "@@ -%d,%d +%d,%d @@ %s\n", h.OriginalStart, h.OriginalLines, h.ReplayStart, h.ReplayLines, h.Input); err != nil {
+			return err
+		}
+		for _, line := range h.Lines {
+			prefix := " "
+			switch line.Op {
+			case Delete:
+				prefix = "-"
+			case Insert:
+				prefix = "+"
+			}
+			if _, err := fmt.Fprintf(w, "%s%s\n", prefix, line.Text); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
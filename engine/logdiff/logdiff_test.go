@@ -0,0 +1,144 @@
+package logdiff
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+	"testing"
+
+	"github.com/ivorytoast/replay78/engine/logreader"
+)
+
+// steps numbers record bodies from seq 1 and splits them into steps
+func steps(t *testing.T, bodies ...string) []logreader.Step {
+	t.Helper()
+	var records []logreader.Record
+	for i, body := range bodies {
+		record, err := logreader.Parse(fmt.Sprintf("%d|%s", i+1, body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		records = append(records, record)
+	}
+	return logreader.SplitSteps(records)
+}
+
+func TestCompareSteps(t *testing.T) {
+	original := []string{"I|ttt|a|", "O|x", "I|ttt|b|", "O|y"}
+	tests := []struct {
+		name          string
+		replay        []string
+		inserted      int
+		deleted       int
+		divergenceSeq int
+		hunks         []string // Hunk headers
+	}{
+		{"equal", original, 0, 0, 0, nil},
+		{"extra output", []string{"I|ttt|a|", "O|x", "O|extra", "I|ttt|b|", "O|y"}, 1, 0, 3, []string{"@@ -2,2 +2,3 @@ ttt|a|"}},
+		{"changed output", []string{"I|ttt|a|", "O|x", "I|ttt|b|", "O|z"}, 1, 1, 4, []string{"@@ -3,2 +3,2 @@ ttt|b|"}},
+		{"missing input", []string{"I|ttt|a|", "O|x"}, 0, 2, 3, []string{"@@ -2,3 +2,1 @@ ttt|b|"}},
+		{"extra input", append(append([]string(nil), original...), "I|ttt|c|", "O|w"), 2, 0, 5, []string{"@@ -4,1 +4,3 @@ ttt|c|"}},
+		{"bad input replayed", []string{"I|ttt|a|", "O|x", "O|Bad Input: garbage", "I|ttt|b|", "O|y"}, 1, 0, 3, []string{"@@ -2,2 +2,3 @@ garbage"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			report := compareSteps("original", "replay", steps(t, original...), steps(t, tt.replay...), 1)
+			if report.Equal != (tt.inserted+tt.deleted == 0) || report.Inserted != tt.inserted || report.Deleted != tt.deleted {
+				t.Errorf("equal %t, +%d -%d; want +%d -%d", report.Equal, report.Inserted, report.Deleted, tt.inserted, tt.deleted)
+			}
+			if report.DivergenceSeq != tt.divergenceSeq {
+				t.Errorf("DivergenceSeq = %d, want %d", report.DivergenceSeq, tt.divergenceSeq)
+			}
+			var headers []string
+			for _, h := range report.Hunks {
+				headers = append(headers, fmt.Sprintf("@@ -%d,%d +%d,%d @@ %s", h.OriginalStart, h.OriginalLines, h.ReplayStart, h.ReplayLines, h.Input))
+			}
+			if strings.Join(headers, "\n") != strings.Join(tt.hunks, "\n") {
+				t.Errorf("hunks\n%s\nwant\n%s", strings.Join(headers, "\n"), strings.Join(tt.hunks, "\n"))
+			}
+		})
+	}
+}
+
+func TestHunksMergeNearbyChanges(t *testing.T) {
+	tests := []struct {
+		name    string
+		gap     int // Equal lines between two changes
+		context int
+		want    int
+	}{
+		{"overlapping context", 3, 2, 1},
+		{"touching context", 4, 2, 1},
+		{"apart", 5, 2, 2},
+		{"no context", 1, 0, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lines := []Line{{Op: Delete, Text: "a", OriginalSeq: 1}}
+			for i := 0; i < tt.gap; i++ {
+				lines = append(lines, Line{Op: Equal, Text: "=", OriginalSeq: 2 + i, ReplaySeq: 1 + i})
+			}
+			lines = append(lines, Line{Op: Insert, Text: "b", ReplaySeq: 1 + tt.gap})
+			inputs := make([]string, len(lines))
+			if got := hunks(lines, inputs, tt.context); len(got) != tt.want {
+				t.Errorf("%d hunks, want %d", len(got), tt.want)
+			}
+		})
+	}
+}
+
+// lcs is the textbook quadratic longest common subsequence length
+func lcs(a, b []string) int {
+	table := make([][]int, len(a)+1)
+	for i := range table {
+		table[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				table[i][j] = table[i+1][j+1] + 1
+			} else {
+				table[i][j] = max(table[i+1][j], table[i][j+1])
+			}
+		}
+	}
+	return table[0][0]
+}
+
+func TestDiffIsShortest(t *testing.T) {
+	random := rand.New(rand.NewSource(78))
+	sequence := func() []string {
+		s := make([]string, random.Intn(40))
+		for i := range s {
+			s[i] = string(rune('a' + random.Intn(4)))
+		}
+		return s
+	}
+	for n := 0; n < 2000; n++ {
+		a, b := sequence(), sequence()
+		var fromA, fromB []string
+		changes := 0
+		for _, e := range diff(a, b) {
+			switch e.op {
+			case Equal:
+				if a[e.a] != b[e.b] {
+					t.Fatalf("diff(%v, %v) matches %s with %s", a, b, a[e.a], b[e.b])
+				}
+				fromA = append(fromA, a[e.a])
+				fromB = append(fromB, b[e.b])
+			case Delete:
+				fromA = append(fromA, a[e.a])
+				changes++
+			case Insert:
+				fromB = append(fromB, b[e.b])
+				changes++
+			}
+		}
+		if strings.Join(fromA, "") != strings.Join(a, "") || strings.Join(fromB, "") != strings.Join(b, "") {
+			t.Fatalf("diff(%v, %v) does not cover both sides in order", a, b)
+		}
+		if want := len(a) + len(b) - 2*lcs(a, b); changes != want {
+			t.Fatalf("diff(%v, %v) has %d changes, want %d", a, b, changes, want)
+		}
+	}
+}
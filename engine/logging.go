@@ -0,0 +1,86 @@
+package engine
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync/atomic"
+)
+
+type LogLevel int32
+
+const (
+	LogDebug LogLevel = iota
+	LogInfo
+	LogWarn
+	LogError
+)
+
+func (l LogLevel) String() string {
+	switch l {
+	case LogDebug:
+		return "debug"
+	case LogInfo:
+		return "info"
+	case LogWarn:
+		return "warn"
+	default:
+		return "error"
+	}
+}
+
+func ParseLogLevel(s string) (LogLevel, error) {
+	for _, level := range []LogLevel{LogDebug, LogInfo, LogWarn, LogError} {
+		if strings.EqualFold(s, level.String()) {
+			return level, nil
+		}
+	}
+	return LogInfo, fmt.Errorf("unknown log level %q", s)
+}
+
+// logLevel applies to engines that have not set their own level and to
+// messages from outside any engine
+var logLevel atomic.Int32
+
+func init() {
+	logLevel.Store(int32(LogInfo))
+}
+
+// SetLogLevel hides engine diagnostics below level, for every engine that
+// has not set its own. It has no effect on the engine log itself.
+func SetLogLevel(level LogLevel) {
+	logLevel.Store(int32(level))
+}
+
+func CurrentLogLevel() LogLevel {
+	return LogLevel(logLevel.Load())
+}
+
+func logf(level LogLevel, format string, args ...interface{}) {
+	if level >= CurrentLogLevel() {
+		log.Printf(format, args...)
+	}
+}
+
+// inheritLogLevel marks an engine that follows CurrentLogLevel
+const inheritLogLevel LogLevel = -1
+
+// SetLogLevel sets the level of this engine alone, as engine|loglevel does,
+// so a replayed command cannot change what other engines in the process,
+// e.g. those of a parallel regression run, print
+func (e *Engine) SetLogLevel(level LogLevel) {
+	e.logLevel.Store(int32(level))
+}
+
+func (e *Engine) LogLevel() LogLevel {
+	if level := LogLevel(e.logLevel.Load()); level != inheritLogLevel {
+		return level
+	}
+	return CurrentLogLevel()
+}
+
+func (e *Engine) logf(level LogLevel, format string, args ...interface{}) {
+	if level >= e.LogLevel() {
+		log.Printf(format, args...)
+	}
+}
@@ -0,0 +1,33 @@
+package engine
+
+import "testing"
+
+func TestLogLevelCommandStaysInEngine(t *testing.T) {
+	tests := []struct {
+		line string
+		want LogLevel
+	}{
+		{"engine|loglevel|error", LogError},
+		{"engine|loglevel|DEBUG", LogDebug},
+		{"engine|loglevel|loud", CurrentLogLevel()},
+	}
+	for _, tt := range tests {
+		t.Run(tt.line, func(t *testing.T) {
+			global := CurrentLogLevel()
+			replay := NewEngineWithSink(NewMemorySink())
+			replay.SetReplayMode()
+			other := NewEngineWithSink(NewMemorySink())
+
+			replay.Apply(Input{Line: tt.line})
+			if got := replay.LogLevel(); got != tt.want {
+				t.Errorf("replaying engine level = %s, want %s", got, tt.want)
+			}
+			if got := other.LogLevel(); got != global {
+				t.Errorf("other engine level = %s, want %s", got, global)
+			}
+			if got := CurrentLogLevel(); got != global {
+				t.Errorf("process level = %s, want %s", got, global)
+			}
+		})
+	}
+}
@@ -0,0 +1,319 @@
+// Package logreader reads engine logs back as typed records. A Reader
+// walks every segment of a run in seq order, can be narrowed with filters,
+// and seeks to any seq through a sparse index, so reaching the end of a long
+// log only scans a few hundred records past the nearest index entry.
+//
+//	r, err := logreader.Open("78-5.log")
+//	...
+//	defer r.Close()
+//	r.Filter(logreader.Kind("I"))
+//	for r.Next() {
+//		input := r.Record().Input
+//		...
+//	}
+//	err = r.Err()
+package logreader
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/ivorytoast/replay78/engine"
+)
+
+// indexInterval is how many records apart the sparse index entries are
+const indexInterval = 256
+
+// Record is one line of an engine log
+type Record struct {
+	Seq   int
+	Kind  string       // I or O
+	Input engine.Input // For I records, the input as submitted
+	Text  string       // For O records, the output text
+	Raw   string       // The line as logged
+}
+
+// Body is the record without its seq, e.g. "O|Current Turn: Player 1"
+func (r Record) Body() string {
+	_, body, _ := strings.Cut(r.Raw, "|")
+	return body
+}
+
+// Topic is the topic of an input record, "" for outputs
+func (r Record) Topic() string {
+	if r.Kind != "I" {
+		return ""
+	}
+	topic, _, _ := strings.Cut(r.Input.Line, "|")
+	return topic
+}
+
+// Parse turns a log line into a Record
+func Parse(line string) (Record, error) {
+	parts := strings.SplitN(line, "|", 3)
+	if len(parts) < 3 {
+		return Record{}, fmt.Errorf("logreader: malformed record %q", line)
+	}
+	seq, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return Record{}, fmt.Errorf("logreader: bad seq in %q", line)
+	}
+	if input, ok := engine.InputFromRecord(parts[1], parts[2]); ok {
+		return Record{Seq: seq, Kind: "I", Input: input, Raw: line}, nil
+	}
+	if parts[1] != "O" {
+		return Record{}, fmt.Errorf("logreader: unknown kind %q in %q", parts[1], line)
+	}
+	return Record{Seq: seq, Kind: "O", Text: parts[2], Raw: line}, nil
+}
+
+// Filter decides whether Next returns a record
+type Filter func(Record) bool
+
+// Kind keeps records of the given kind, I or O
+func Kind(kind string) Filter {
+	return func(r Record) bool {
+		return r.Kind == kind
+	}
+}
+
+// Topic keeps input records on any of the given topics
+func Topic(topics ...string) Filter {
+	return func(r Record) bool {
+		if r.Kind != "I" {
+			return false
+		}
+		topic := r.Topic()
+		for _, t := range topics {
+			if topic == t {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+type indexEntry struct {
+	seq     int
+	segment int
+	offset  int64
+}
+
+// Reader iterates over the records of a log. A trailing line that is still
+// being written is left for a later Reader rather than returned half done.
+type Reader struct {
+	segments []string
+	filters  []Filter
+	index    []indexEntry
+
+	segment int // Index into segments of the open file
+	file    *os.File
+	reader  *bufio.Reader
+	from    int // Records before this seq are skipped, see Seek
+
+	record Record
+	err    error
+}
+
+// Open reads every segment of the run whose log starts at logFile
+func Open(logFile string) (*Reader, error) {
+	segments := engine.LogSegments(logFile)
+	if len(segments) == 0 {
+		_, err := os.Stat(logFile)
+		return nil, err
+	}
+	return OpenSegments(segments), nil
+}
+
+// OpenSegments reads the given files as one log, in order
+func OpenSegments(segments []string) *Reader {
+	return &Reader{segments: segments}
+}
+
+// ReadInputs returns every input in a run's log, in seq order
+func ReadInputs(logFile string) ([]engine.Input, error) {
+	r, err := Open(logFile)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	r.Filter(Kind("I"))
+
+	var inputs []engine.Input
+	for r.Next() {
+		inputs = append(inputs, r.Record().Input)
+	}
+	return inputs, r.Err()
+}
+
+// LastOffset returns the offset recorded by the last input from source in a
+// run's log, and whether there was one. FileTailGenerator resumes from it.
+func LastOffset(logFile string, source engine.Source) (int64, bool, error) {
+	r, err := Open(logFile)
+	if os.IsNotExist(err) {
+		return 0, false, nil
+	} else if err != nil {
+		return 0, false, err
+	}
+	defer r.Close()
+	r.Filter(Kind("I"))
+
+	var offset int64
+	found := false
+	for r.Next() {
+		if input := r.Record().Input; input.Source == source {
+			offset, found = input.Offset, true
+		}
+	}
+	return offset, found, r.Err()
+}
+
+// Filter narrows the records Next returns to those every filter keeps
+func (r *Reader) Filter(filters ...Filter) {
+	r.filters = append(r.filters, filters...)
+}
+
+func (r *Reader) Close() error {
+	if r.file == nil {
+		return nil
+	}
+	err := r.file.Close()
+	r.file = nil
+	return err
+}
+
+// Next advances to the next record, returning false at the end of the log
+// or on an error, which Err then reports. Malformed lines are skipped.
+func (r *Reader) Next() bool {
+	r.record = Record{}
+	for r.err == nil {
+		line, ok := r.readLine()
+		if !ok {
+			return false
+		}
+		record, err := Parse(line)
+		if err != nil || record.Seq < r.from || !r.keep(record) {
+			continue
+		}
+		r.record = record
+		return true
+	}
+	return false
+}
+
+func (r *Reader) Record() Record {
+	return r.record
+}
+
+func (r *Reader) Err() error {
+	return r.err
+}
+
+func (r *Reader) keep(record Record) bool {
+	for _, filter := range r.filters {
+		if !filter(record) {
+			return false
+		}
+	}
+	return true
+}
+
+// readLine returns the next complete line across segments
+func (r *Reader) readLine() (string, bool) {
+	for {
+		if r.file == nil {
+			if r.segment >= len(r.segments) {
+				return "", false
+			}
+			if err := r.open(r.segment, 0); err != nil {
+				r.err = err
+				return "", false
+			}
+		}
+		line, err := r.reader.ReadString('\n')
+		if errors.Is(err, io.EOF) {
+			r.Close()
+			r.segment++
+			continue
+		} else if err != nil {
+			r.err = err
+			return "", false
+		}
+		return strings.TrimSuffix(line, "\n"), true
+	}
+}
+
+func (r *Reader) open(segment int, offset int64) error {
+	r.Close()
+	file, err := os.Open(r.segments[segment])
+	if err != nil {
+		return err
+	}
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		file.Close()
+		return err
+	}
+	r.file = file
+	r.reader = bufio.NewReader(file)
+	r.segment = segment
+	return nil
+}
+
+// Seek positions the reader so that Next returns the first record at or
+// after seq that the filters keep. The index is built on the first Seek.
+func (r *Reader) Seek(seq int) error {
+	if r.index == nil {
+		if err := r.buildIndex(); err != nil {
+			return err
+		}
+	}
+	r.err = nil
+	r.from = seq
+
+	// Last entry at or before seq
+	i := sort.Search(len(r.index), func(i int) bool {
+		return r.index[i].seq > seq
+	}) - 1
+	if i < 0 {
+		r.Close()
+		r.segment = 0
+		return nil
+	}
+	return r.open(r.index[i].segment, r.index[i].offset)
+}
+
+// buildIndex scans the log once, noting the position of every
+// indexInterval-th record and of the first record of every segment
+func (r *Reader) buildIndex() error {
+	r.index = []indexEntry{}
+	for segment, path := range r.segments {
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		reader := bufio.NewReader(file)
+		var offset int64
+		count := 0
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				break
+			}
+			if record, err := Parse(strings.TrimSuffix(line, "\n")); err == nil {
+				if count%indexInterval == 0 {
+					r.index = append(r.index, indexEntry{seq: record.Seq, segment: segment, offset: offset})
+				}
+				count++
+			}
+			offset += int64(len(line))
+		}
+		file.Close()
+	}
+	return nil
+}
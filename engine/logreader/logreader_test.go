@@ -0,0 +1,204 @@
+package logreader
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ivorytoast/replay78/engine"
+)
+
+// writeLog runs inputs through an engine logging to a fresh file and
+// returns the file
+func writeLog(t *testing.T, inputs []engine.Input) string {
+	t.Helper()
+	logFile := filepath.Join(t.TempDir(), "78.log")
+	e := engine.NewEngineWithLogFile(logFile)
+	e.SetReplayMode()
+	for _, input := range inputs {
+		e.Apply(input)
+	}
+	if err := e.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return logFile
+}
+
+func TestLastOffset(t *testing.T) {
+	tail := engine.Source{Kind: "tail", ID: "tail"}
+	other := engine.Source{Kind: "tail", ID: "other"}
+	tests := []struct {
+		name      string
+		inputs    []engine.Input
+		want      int64
+		wantFound bool
+	}{
+		{"no inputs from source", []engine.Input{{Line: "ttt|new|", Source: other, Offset: 9}}, 0, false},
+		{"last input wins", []engine.Input{
+			{Line: "ttt|new|", Source: tail, Offset: 9},
+			{Line: "ttt|show|", Source: other, Offset: 40},
+			{Line: "ttt|show|", Source: tail, Offset: 19},
+		}, 19, true},
+		{"later segment", []engine.Input{
+			{Line: "ttt|new|", Source: tail, Offset: 9},
+			{Line: "engine|rotate|"},
+			{Line: "ttt|show|", Source: tail, Offset: 19},
+			{Line: "engine|rotate|"},
+			{Line: "ttt|show|", Source: other, Offset: 50},
+		}, 19, true},
+		{"rejected line still counts", []engine.Input{
+			{Line: "ttt|new|", Source: tail, Offset: 9},
+			{Line: "ttt|bogus|", Source: tail, Offset: 20},
+		}, 20, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, found, err := LastOffset(writeLog(t, tt.inputs), tail)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want || found != tt.wantFound {
+				t.Errorf("LastOffset = %d, %t; want %d, %t", got, found, tt.want, tt.wantFound)
+			}
+		})
+	}
+
+	if _, found, err := LastOffset(filepath.Join(t.TempDir(), "missing.log"), tail); err != nil || found {
+		t.Errorf("LastOffset of a missing log = %t, %v; want nothing", found, err)
+	}
+}
+
+// writeSegments writes records 1 to the sum of sizes across one file per
+// size, every tenth of them an input
+func writeSegments(t *testing.T, sizes ...int) []string {
+	t.Helper()
+	dir := t.TempDir()
+	var segments []string
+	seq := 1
+	for i, size := range sizes {
+		var b strings.Builder
+		for end := seq + size; seq < end; seq++ {
+			if seq%10 == 0 {
+				fmt.Fprintf(&b, "%d|I|ttt|show|%d\n", seq, seq)
+			} else {
+				fmt.Fprintf(&b, "%d|O|line %d\n", seq, seq)
+			}
+		}
+		path := filepath.Join(dir, fmt.Sprintf("78.log.%d", i+1))
+		if err := os.WriteFile(path, []byte(b.String()), 0644); err != nil {
+			t.Fatal(err)
+		}
+		segments = append(segments, path)
+	}
+	return segments
+}
+
+func TestSparseIndex(t *testing.T) {
+	r := OpenSegments(writeSegments(t, 300, 400, 300))
+	defer r.Close()
+	if err := r.Seek(1); err != nil {
+		t.Fatal(err)
+	}
+	var seqs []int
+	for _, entry := range r.index {
+		seqs = append(seqs, entry.seq)
+	}
+	// Every indexInterval-th record of each segment, starting with its first
+	if want := []int{1, 257, 301, 557, 701, 957}; fmt.Sprint(seqs) != fmt.Sprint(want) {
+		t.Errorf("index at seqs %v, want %v", seqs, want)
+	}
+}
+
+func TestSeek(t *testing.T) {
+	tests := []struct {
+		name    string
+		seq     int
+		filters []Filter
+		want    int // Seq of the first record Next returns, 0 for none
+	}{
+		{"before the start", 0, nil, 1},
+		{"first record", 1, nil, 1},
+		{"on an index entry", 257, nil, 257},
+		{"just before an index entry", 256, nil, 256},
+		{"last of a segment", 300, nil, 300},
+		{"first of a segment", 301, nil, 301},
+		{"between entries", 640, nil, 640},
+		{"last record", 1000, nil, 1000},
+		{"past the end", 1001, nil, 0},
+		{"next kept input", 255, []Filter{Kind("I")}, 260},
+		{"input across a segment", 691, []Filter{Kind("I")}, 700},
+		{"no input after", 995, []Filter{Kind("O"), Topic("ttt")}, 0},
+	}
+	r := OpenSegments(writeSegments(t, 300, 400, 300))
+	defer r.Close()
+	// One reader seeks back and forth, as the index is only built once
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r.filters = tt.filters
+			if err := r.Seek(tt.seq); err != nil {
+				t.Fatal(err)
+			}
+			got := 0
+			if r.Next() {
+				got = r.Record().Seq
+			}
+			if got != tt.want || r.Err() != nil {
+				t.Errorf("Seek(%d) then Next at seq %d (err %v), want %d", tt.seq, got, r.Err(), tt.want)
+			}
+		})
+	}
+}
+
+func TestReaderLeavesPartialLine(t *testing.T) {
+	segments := writeSegments(t, 5)
+	file, err := os.OpenFile(segments[0], os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.WriteString("6|O|half writ")
+	file.Close()
+
+	r := OpenSegments(segments)
+	defer r.Close()
+	last := 0
+	for r.Next() {
+		last = r.Record().Seq
+	}
+	if last != 5 || r.Err() != nil {
+		t.Errorf("read up to seq %d (err %v), want 5", last, r.Err())
+	}
+}
+
+func TestSplitSteps(t *testing.T) {
+	tests := []struct {
+		name  string
+		lines []string
+		want  []string // Each step as "input:records"
+	}{
+		{"inputs and outputs", []string{"1|I|ttt|new|", "2|O|a", "3|O|b", "4|I|ttt|show|", "5|O|c"}, []string{"ttt|new|:3", "ttt|show|:2"}},
+		{"bad input is a step", []string{"1|I|ttt|new|", "2|O|Bad Input: junk", "3|I|ttt|show|"}, []string{"ttt|new|:1", "junk:1", "ttt|show|:1"}},
+		{"leading outputs dropped", []string{"1|O|banner", "2|I|ttt|new|", "3|O|a"}, []string{"ttt|new|:2"}},
+		{"nothing", nil, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var records []Record
+			for _, line := range tt.lines {
+				record, err := Parse(line)
+				if err != nil {
+					t.Fatal(err)
+				}
+				records = append(records, record)
+			}
+			var got []string
+			for _, step := range SplitSteps(records) {
+				got = append(got, fmt.Sprintf("%s:%d", step.Input.Line, len(step.Records)))
+			}
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("steps %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,49 @@
+package logreader
+
+import (
+	"strings"
+
+	"github.com/ivorytoast/replay78/engine"
+)
+
+// Step is one input of a logged run together with the records it wrote. A
+// line the engine rejected has no I record, only its Bad Input output, and
+// is kept as a step of its own so a replay submits it again.
+type Step struct {
+	Input   engine.Input
+	Seq     int      // Seq of the first record the step wrote
+	Records []string // The step's records without their seqs, see Record.Body
+}
+
+// ReadSteps splits the log of a run, every segment of it, into the steps
+// its inputs made
+func ReadSteps(logFile string) ([]Step, error) {
+	r, err := Open(logFile)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	var records []Record
+	for r.Next() {
+		records = append(records, r.Record())
+	}
+	return SplitSteps(records), r.Err()
+}
+
+// SplitSteps groups records, in seq order, into the steps that wrote them
+func SplitSteps(records []Record) []Step {
+	var steps []Step
+	for _, record := range records {
+		if record.Kind == "I" {
+			steps = append(steps, Step{Input: record.Input, Seq: record.Seq})
+		} else if line, ok := strings.CutPrefix(record.Text, "Bad Input: "); ok {
+			steps = append(steps, Step{Input: engine.Input{Line: line}, Seq: record.Seq})
+		} else if len(steps) == 0 {
+			continue
+		}
+		last := &steps[len(steps)-1]
+		last.Records = append(last.Records, record.Body())
+	}
+	return steps
+}
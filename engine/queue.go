@@ -0,0 +1,262 @@
+package engine
+
+import (
+	"errors"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Priority selects the lane an input waits in. Higher lanes are drained
+// first, and OverloadDropLowest evicts from the lowest lane. A lane passed
+// over QueueConfig.MaxSkips times in a row is served once anyway, so a
+// steady stream of player inputs cannot hold back ticks forever.
+type Priority int
+
+const (
+	PriorityDefault Priority = iota // Use the topic's priority
+	PriorityTick                    // System ticks, safe to drop
+	PriorityPlayer                  // Player moves (default for unknown topics)
+	PriorityAdmin                   // Administrative commands
+	numPriorities
+)
+
+func (p Priority) String() string {
+	switch p {
+	case PriorityTick:
+		return "tick"
+	case PriorityPlayer:
+		return "player"
+	case PriorityAdmin:
+		return "admin"
+	}
+	return "default"
+}
+
+func ParsePriority(s string) Priority {
+	switch s {
+	case "tick":
+		return PriorityTick
+	case "player":
+		return PriorityPlayer
+	case "admin":
+		return PriorityAdmin
+	}
+	return PriorityDefault
+}
+
+type OverloadPolicy int
+
+const (
+	OverloadBlock      OverloadPolicy = iota // Wait for space, forever or until Timeout
+	OverloadReject                           // Fail immediately with ErrQueueFull
+	OverloadDropLowest                       // Evict the oldest lower-priority input to make room
+)
+
+type QueueConfig struct {
+	Capacity int
+	Policy   OverloadPolicy
+	Timeout  time.Duration // Only used by OverloadBlock, 0 waits forever
+	MaxSkips int           // Pops a waiting lane can be passed over, 0 for strict priority
+}
+
+var (
+	ErrQueueFull    = errors.New("engine: input queue full")
+	ErrQueueTimeout = errors.New("engine: timed out waiting for input queue")
+	ErrDropped      = errors.New("engine: input dropped from a full queue")
+)
+
+func DefaultQueueConfig() QueueConfig {
+	return QueueConfig{
+		Capacity: 100,
+		Policy:   OverloadBlock,
+		MaxSkips: 32,
+	}
+}
+
+type queuedInput struct {
+	input    Input
+	priority Priority    // Resolved lane, never PriorityDefault
+	reply    chan Result // Optional, receives the result once processed, closed if the input is dropped
+	inspect  func()      // Set instead of input by Engine.Inspect
+	// Committed inputs have already been ordered elsewhere, so they bypass
+	// the capacity and are never evicted
+	committed bool
+}
+
+// inputQueue holds one FIFO lane per priority. Capacity is shared across
+// all lanes. Unless ordered is set, pop always takes from the highest
+// non-empty lane, so the merge depends only on what is queued.
+type inputQueue struct {
+	mu      sync.Mutex
+	lanes   [numPriorities][]queuedInput
+	size    int
+	ordered bool               // Single FIFO, used when replaying an already merged log
+	paused  bool               // Only the admin lane is served, see setPaused
+	skipped [numPriorities]int // Pops each lane has waited through, see nextLane
+	config  QueueConfig
+	ready   chan struct{} // Signalled when an item is added
+	space   chan struct{} // Signalled when an item is removed
+	logf    func(level LogLevel, format string, args ...interface{})
+}
+
+func newInputQueue(config QueueConfig) *inputQueue {
+	if config.Capacity <= 0 {
+		config.Capacity = DefaultQueueConfig().Capacity
+	}
+	return &inputQueue{
+		config: config,
+		logf:   logf,
+		ready:  make(chan struct{}, 1),
+		space:  make(chan struct{}, 1),
+	}
+}
+
+// push adds an input according to the overload policy. When wait is false
+// it never blocks, even under OverloadBlock.
+func (q *inputQueue) push(item queuedInput, wait bool) error {
+	var deadline <-chan time.Time
+	if wait && q.config.Policy == OverloadBlock && q.config.Timeout > 0 {
+		timer := time.NewTimer(q.config.Timeout)
+		defer timer.Stop()
+		deadline = timer.C
+	}
+
+	for {
+		q.mu.Lock()
+		// While paused a full queue cannot drain, so admin inputs are let
+		// past the capacity or there would be no way to resume
+		if q.size < q.config.Capacity || item.committed || (q.paused && item.priority == PriorityAdmin) {
+			q.add(item)
+			hasSpace := q.size < q.config.Capacity
+			q.mu.Unlock()
+			signal(q.ready)
+			// Pass the wakeup on in case other writers are still waiting
+			if hasSpace {
+				signal(q.space)
+			}
+			return nil
+		}
+
+		if q.config.Policy == OverloadDropLowest {
+			err := q.evictLowest(item)
+			q.mu.Unlock()
+			if err == nil {
+				signal(q.ready)
+			}
+			return err
+		}
+
+		if q.config.Policy == OverloadReject || !wait {
+			q.mu.Unlock()
+			return ErrQueueFull
+		}
+		q.mu.Unlock()
+
+		select {
+		case <-q.space:
+		case <-deadline:
+			return ErrQueueTimeout
+		}
+	}
+}
+
+func (q *inputQueue) lane(item queuedInput) int {
+	if q.ordered {
+		return int(PriorityDefault)
+	}
+	return int(item.priority)
+}
+
+// add appends to the item's lane. Caller must hold q.mu.
+func (q *inputQueue) add(item queuedInput) {
+	lane := q.lane(item)
+	q.lanes[lane] = append(q.lanes[lane], item)
+	q.size++
+}
+
+// evictLowest replaces the oldest uncommitted input in the lowest lane
+// that has one, provided that lane ranks below the incoming input. Anyone
+// waiting on the dropped input is woken by closing its reply. Caller must
+// hold q.mu.
+func (q *inputQueue) evictLowest(item queuedInput) error {
+	for lane := range q.lanes {
+		i := slices.IndexFunc(q.lanes[lane], func(queued queuedInput) bool { return !queued.committed })
+		if i < 0 {
+			continue
+		}
+		dropped := q.lanes[lane][i]
+		if dropped.priority >= item.priority {
+			return ErrQueueFull
+		}
+		q.lanes[lane] = slices.Delete(q.lanes[lane], i, i+1)
+		q.size--
+		q.add(item)
+		if dropped.reply != nil {
+			close(dropped.reply)
+		}
+		q.logf(LogWarn, "Queue full, dropped input: %s", dropped.input.Line)
+		return nil
+	}
+	return ErrQueueFull
+}
+
+// setPaused holds back every lane but the admin one, so the engine can
+// still be inspected and resumed while dispatch is paused
+func (q *inputQueue) setPaused(paused bool) {
+	q.mu.Lock()
+	q.paused = paused
+	q.mu.Unlock()
+}
+
+func (q *inputQueue) pop() queuedInput {
+	for {
+		q.mu.Lock()
+		if lane := q.nextLane(); lane >= 0 {
+			item := q.lanes[lane][0]
+			q.lanes[lane] = q.lanes[lane][1:]
+			q.size--
+			q.mu.Unlock()
+			signal(q.space)
+			return item
+		}
+		q.mu.Unlock()
+		<-q.ready
+	}
+}
+
+// nextLane picks the lane to pop from, or -1 if none can be served. That is
+// the highest non-empty lane, unless a lower one has been passed over
+// MaxSkips times, in which case it goes first. The counts only change on
+// pop, so the merge still depends only on what is queued. Caller must hold
+// q.mu.
+func (q *inputQueue) nextLane() int {
+	next := -1
+	for lane := len(q.lanes) - 1; lane >= 0; lane-- {
+		if len(q.lanes[lane]) == 0 || (q.paused && lane != int(PriorityAdmin)) {
+			continue
+		}
+		if next < 0 || (q.config.MaxSkips > 0 && q.skipped[lane] >= q.config.MaxSkips && q.skipped[next] < q.config.MaxSkips) {
+			next = lane
+		}
+	}
+	if next < 0 {
+		return next
+	}
+	for lane := range q.lanes {
+		if lane == next {
+			q.skipped[lane] = 0
+		} else if len(q.lanes[lane]) > 0 && !(q.paused && lane != int(PriorityAdmin)) {
+			q.skipped[lane]++
+		}
+	}
+	return next
+}
+
+// signal performs a non-blocking send on a wakeup channel
+func signal(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
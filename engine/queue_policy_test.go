@@ -0,0 +1,91 @@
+package engine
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestQueueOverloadPolicies(t *testing.T) {
+	tests := []struct {
+		name     string
+		config   QueueConfig
+		full     []Priority // Lanes of the inputs filling the queue
+		incoming Priority
+		wait     bool
+		wantErr  error
+		dropped  int // Index into full of the evicted input, -1 for none
+	}{
+		{"block times out", QueueConfig{Capacity: 2, Policy: OverloadBlock, Timeout: 10 * time.Millisecond}, []Priority{PriorityTick, PriorityPlayer}, PriorityAdmin, true, ErrQueueTimeout, -1},
+		{"block without wait", QueueConfig{Capacity: 2, Policy: OverloadBlock}, []Priority{PriorityTick, PriorityPlayer}, PriorityAdmin, false, ErrQueueFull, -1},
+		{"reject", QueueConfig{Capacity: 2, Policy: OverloadReject}, []Priority{PriorityTick, PriorityPlayer}, PriorityAdmin, true, ErrQueueFull, -1},
+		{"drop lowest evicts oldest tick", QueueConfig{Capacity: 3, Policy: OverloadDropLowest}, []Priority{PriorityPlayer, PriorityTick, PriorityTick}, PriorityPlayer, true, nil, 1},
+		{"drop lowest evicts player for admin", QueueConfig{Capacity: 2, Policy: OverloadDropLowest}, []Priority{PriorityPlayer, PriorityAdmin}, PriorityAdmin, true, nil, 0},
+		{"drop lowest keeps equal lane", QueueConfig{Capacity: 2, Policy: OverloadDropLowest}, []Priority{PriorityPlayer, PriorityPlayer}, PriorityPlayer, true, ErrQueueFull, -1},
+		{"drop lowest keeps higher lane", QueueConfig{Capacity: 1, Policy: OverloadDropLowest}, []Priority{PriorityAdmin}, PriorityTick, true, ErrQueueFull, -1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := newInputQueue(tt.config)
+			var full []queuedInput
+			for i, priority := range tt.full {
+				item := queuedAt(priority.String(), priority)
+				if err := q.push(item, true); err != nil {
+					t.Fatalf("filling input %d: %v", i, err)
+				}
+				full = append(full, item)
+			}
+
+			err := q.push(queuedAt("incoming", tt.incoming), tt.wait)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("push = %v, want %v", err, tt.wantErr)
+			}
+			if q.size != tt.config.Capacity {
+				t.Errorf("size = %d, want %d", q.size, tt.config.Capacity)
+			}
+			for i, item := range full {
+				select {
+				case _, ok := <-item.reply:
+					if ok || i != tt.dropped {
+						t.Errorf("input %d replied to, dropped %t", i, !ok)
+					}
+				default:
+					if i == tt.dropped {
+						t.Errorf("input %d was dropped without closing its reply", i)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestQueuePopOrder(t *testing.T) {
+	tests := []struct {
+		name    string
+		ordered bool
+		paused  bool
+		push    []Priority
+		want    []string
+	}{
+		{"highest lane first", false, false, []Priority{PriorityTick, PriorityPlayer, PriorityAdmin, PriorityPlayer}, []string{"2", "1", "3", "0"}},
+		{"ordered keeps arrival order", true, false, []Priority{PriorityTick, PriorityPlayer, PriorityAdmin}, []string{"0", "1", "2"}},
+		{"paused serves admin only", false, true, []Priority{PriorityPlayer, PriorityAdmin, PriorityTick, PriorityAdmin}, []string{"1", "3"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := newInputQueue(QueueConfig{Capacity: 10})
+			q.ordered = tt.ordered
+			q.setPaused(tt.paused)
+			for i, priority := range tt.push {
+				if err := q.push(queuedAt(string(rune('0'+i)), priority), false); err != nil {
+					t.Fatal(err)
+				}
+			}
+			for _, want := range tt.want {
+				if got := q.pop().input.Line; got != want {
+					t.Errorf("pop = %s, want %s", got, want)
+				}
+			}
+		})
+	}
+}
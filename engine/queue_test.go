@@ -0,0 +1,162 @@
+package engine
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func queuedAt(line string, priority Priority) queuedInput {
+	return queuedInput{input: Input{Line: line}, priority: priority, reply: make(chan Result, 1)}
+}
+
+func TestSubmitWaitDroppedInput(t *testing.T) {
+	e := NewEngineWithSink(NewMemorySink())
+	e.SetQueueConfig(QueueConfig{Capacity: 1, Policy: OverloadDropLowest})
+
+	// Nothing runs the engine, so the tick stays queued until the player
+	// input evicts it
+	errc := make(chan error, 1)
+	go func() {
+		_, err := e.SubmitWait(Input{Line: "tick|tick|", Priority: PriorityTick})
+		errc <- err
+	}()
+	for {
+		e.queue.mu.Lock()
+		queued := e.queue.size
+		e.queue.mu.Unlock()
+		if queued == 1 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if err := e.TrySubmit(Input{Line: "ttt|move|0 0 0 0", Priority: PriorityPlayer}); err != nil {
+		t.Fatalf("TrySubmit = %v", err)
+	}
+
+	select {
+	case err := <-errc:
+		if !errors.Is(err, ErrDropped) {
+			t.Errorf("SubmitWait = %v, want ErrDropped", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("SubmitWait of a dropped input never returned")
+	}
+}
+
+func TestDeliverCommittedInputs(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy OverloadPolicy
+	}{
+		{"block", OverloadBlock},
+		{"reject", OverloadReject},
+		{"drop lowest", OverloadDropLowest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := NewEngineWithSink(NewMemorySink())
+			e.DisableGenerator("tick")
+			e.SetQueueConfig(QueueConfig{Capacity: 1, Policy: tt.policy})
+
+			// Nothing runs the engine yet, so the committed inputs overfill
+			// the queue
+			lines := []string{"tick|tick|", "ttt|new|", "ttt|move|0 0 0 0"}
+			errc := make(chan error, len(lines))
+			for _, line := range lines {
+				go func() {
+					_, err := e.Deliver(Input{Line: line, Priority: PriorityTick})
+					errc <- err
+				}()
+			}
+			for {
+				e.queue.mu.Lock()
+				queued := e.queue.size
+				e.queue.mu.Unlock()
+				if queued == len(lines) {
+					break
+				}
+				time.Sleep(time.Millisecond)
+			}
+			if err := e.TrySubmit(Input{Line: "ttt|new|", Priority: PriorityAdmin}); !errors.Is(err, ErrQueueFull) {
+				t.Errorf("TrySubmit over committed inputs = %v, want ErrQueueFull", err)
+			}
+
+			e.Run()
+			for range lines {
+				select {
+				case err := <-errc:
+					if err != nil {
+						t.Errorf("Deliver = %v", err)
+					}
+				case <-time.After(time.Second):
+					t.Fatal("committed input was never processed")
+				}
+			}
+		})
+	}
+}
+
+func TestQueueAging(t *testing.T) {
+	tests := []struct {
+		name     string
+		maxSkips int
+		players  int
+		wantTick int // Pops before the tick is served
+	}{
+		{"strict priority starves the tick", 0, 10, 10},
+		{"tick served after max skips", 3, 10, 3},
+		{"tick waits behind fewer inputs", 5, 2, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := newInputQueue(QueueConfig{Capacity: 20, MaxSkips: tt.maxSkips})
+			q.push(queuedAt("tick", PriorityTick), false)
+			for i := 0; i < tt.players; i++ {
+				q.push(queuedAt("player", PriorityPlayer), false)
+			}
+			for i := 0; i <= tt.players; i++ {
+				if q.pop().input.Line == "tick" {
+					if i != tt.wantTick {
+						t.Errorf("tick served after %d pops, want %d", i, tt.wantTick)
+					}
+					return
+				}
+			}
+			t.Error("tick never served")
+		})
+	}
+}
+
+func TestInspectOnFullQueue(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy OverloadPolicy
+		fill   Priority
+	}{
+		{"reject", OverloadReject, PriorityPlayer},
+		{"drop lowest with admin lane full", OverloadDropLowest, PriorityAdmin},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := NewEngineWithSink(NewMemorySink())
+			e.SetQueueConfig(QueueConfig{Capacity: 1, Policy: tt.policy})
+			// Nothing drains the queue as the engine is not running
+			if err := e.queue.push(queuedAt("fill", tt.fill), false); err != nil {
+				t.Fatal(err)
+			}
+
+			ran := false
+			done := make(chan error, 1)
+			go func() { done <- e.Inspect(func() { ran = true }) }()
+			select {
+			case err := <-done:
+				if !errors.Is(err, ErrQueueFull) || ran {
+					t.Errorf("Inspect = %v, ran %t; want ErrQueueFull without running", err, ran)
+				}
+			case <-time.After(time.Second):
+				t.Fatal("Inspect blocked on a full queue")
+			}
+		})
+	}
+}
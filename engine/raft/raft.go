@@ -0,0 +1,484 @@
+// Package raft is a small, self-contained Raft implementation used to agree
+// on the engine's input order across a cluster of replay78 nodes. It keeps
+// its log in memory: a node that restarts rejoins empty and is caught up by
+// the leader.
+package raft
+
+import (
+	"log"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+const (
+	tickInterval       = 10 * time.Millisecond
+	heartbeatInterval  = 50 * time.Millisecond
+	electionTimeoutMin = 150 * time.Millisecond
+	electionTimeoutMax = 300 * time.Millisecond
+	maxAppendBatch     = 64
+)
+
+type State int
+
+const (
+	Follower State = iota
+	Candidate
+	Leader
+)
+
+func (s State) String() string {
+	switch s {
+	case Candidate:
+		return "candidate"
+	case Leader:
+		return "leader"
+	}
+	return "follower"
+}
+
+type Entry struct {
+	Term  int    `json:"term"`
+	Index int    `json:"index"`
+	Data  string `json:"data"` // Empty for the no-op a new leader appends
+}
+
+type VoteRequest struct {
+	Term         int    `json:"term"`
+	CandidateID  string `json:"candidateId"`
+	LastLogIndex int    `json:"lastLogIndex"`
+	LastLogTerm  int    `json:"lastLogTerm"`
+}
+
+type VoteResponse struct {
+	Term    int  `json:"term"`
+	Granted bool `json:"granted"`
+}
+
+type AppendRequest struct {
+	Term         int     `json:"term"`
+	LeaderID     string  `json:"leaderId"`
+	PrevLogIndex int     `json:"prevLogIndex"`
+	PrevLogTerm  int     `json:"prevLogTerm"`
+	Entries      []Entry `json:"entries"`
+	LeaderCommit int     `json:"leaderCommit"`
+}
+
+type AppendResponse struct {
+	Term         int  `json:"term"`
+	Success      bool `json:"success"`
+	LastLogIndex int  `json:"lastLogIndex"` // Lets the leader skip back quickly on failure
+}
+
+// Handler is the receiving side of the two Raft RPCs
+type Handler interface {
+	HandleRequestVote(req VoteRequest) VoteResponse
+	HandleAppendEntries(req AppendRequest) AppendResponse
+}
+
+// Transport is the sending side of the two Raft RPCs
+type Transport interface {
+	RequestVote(from, to string, req VoteRequest) (VoteResponse, error)
+	AppendEntries(from, to string, req AppendRequest) (AppendResponse, error)
+}
+
+type Node struct {
+	id        string
+	peers     []string // Everyone except this node
+	transport Transport
+	apply     func(entry Entry)
+	rng       *rand.Rand
+
+	mu          sync.Mutex
+	state       State
+	currentTerm int
+	votedFor    string
+	leaderID    string
+	entries     []Entry // entries[0] is a sentinel so indexes start at 1
+	commitIndex int
+	lastApplied int
+	nextIndex   map[string]int
+	matchIndex  map[string]int
+	votes       int
+
+	electionDeadline time.Time
+	lastHeartbeat    time.Time
+
+	applyReady chan struct{}
+	stop       chan struct{}
+}
+
+// NewNode creates a cluster member. apply is called with every committed
+// entry, in log order, from a single goroutine.
+func NewNode(id string, peers []string, transport Transport, apply func(entry Entry)) *Node {
+	var seed int64
+	for _, c := range id {
+		seed = seed*31 + int64(c)
+	}
+	n := &Node{
+		id:         id,
+		peers:      peers,
+		transport:  transport,
+		apply:      apply,
+		rng:        rand.New(rand.NewSource(time.Now().UnixNano() + seed)),
+		entries:    []Entry{{}},
+		nextIndex:  make(map[string]int),
+		matchIndex: make(map[string]int),
+		applyReady: make(chan struct{}, 1),
+		stop:       make(chan struct{}),
+	}
+	n.resetElectionDeadline()
+	return n
+}
+
+func (n *Node) ID() string {
+	return n.id
+}
+
+func (n *Node) Start() {
+	go n.tickLoop()
+	go n.applyLoop()
+}
+
+func (n *Node) Stop() {
+	close(n.stop)
+}
+
+func (n *Node) Status() (state State, term int, leaderID string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.state, n.currentTerm, n.leaderID
+}
+
+func (n *Node) IsLeader() bool {
+	state, _, _ := n.Status()
+	return state == Leader
+}
+
+// CommitIndex returns the highest log index known to be committed
+func (n *Node) CommitIndex() int {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.commitIndex
+}
+
+// Propose appends data to the log if this node is the leader. The entry is
+// only committed once a majority has stored it, and may still be replaced
+// if leadership changes first, which the caller can detect by comparing
+// the term of the entry applied at index.
+func (n *Node) Propose(data string) (index int, term int, isLeader bool) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.state != Leader {
+		return 0, 0, false
+	}
+	index = n.lastIndex() + 1
+	n.entries = append(n.entries, Entry{Term: n.currentTerm, Index: index, Data: data})
+	// Without peers the leader is the majority on its own
+	n.advanceCommit()
+	n.broadcastAppend()
+	return index, n.currentTerm, true
+}
+
+func (n *Node) tickLoop() {
+	ticker := time.NewTicker(tickInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			n.tick()
+		case <-n.stop:
+			return
+		}
+	}
+}
+
+func (n *Node) tick() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	now := time.Now()
+	if n.state == Leader {
+		if now.Sub(n.lastHeartbeat) >= heartbeatInterval {
+			n.broadcastAppend()
+		}
+		return
+	}
+	if now.After(n.electionDeadline) {
+		n.startElection()
+	}
+}
+
+func (n *Node) applyLoop() {
+	for {
+		select {
+		case <-n.applyReady:
+		case <-n.stop:
+			return
+		}
+
+		n.mu.Lock()
+		var committed []Entry
+		if n.commitIndex > n.lastApplied {
+			committed = append(committed, n.entries[n.lastApplied+1:n.commitIndex+1]...)
+			n.lastApplied = n.commitIndex
+		}
+		n.mu.Unlock()
+
+		for _, entry := range committed {
+			n.apply(entry)
+		}
+	}
+}
+
+func (n *Node) signalApply() {
+	select {
+	case n.applyReady <- struct{}{}:
+	default:
+	}
+}
+
+func (n *Node) resetElectionDeadline() {
+	spread := int64(electionTimeoutMax - electionTimeoutMin)
+	n.electionDeadline = time.Now().Add(electionTimeoutMin + time.Duration(n.rng.Int63n(spread)))
+}
+
+func (n *Node) lastIndex() int {
+	return len(n.entries) - 1
+}
+
+func (n *Node) lastTerm() int {
+	return n.entries[len(n.entries)-1].Term
+}
+
+func (n *Node) clusterSize() int {
+	return len(n.peers) + 1
+}
+
+// stepDown moves to a newer term as a follower. Caller must hold n.mu.
+func (n *Node) stepDown(term int) {
+	if term > n.currentTerm {
+		n.currentTerm = term
+		n.votedFor = ""
+		n.leaderID = ""
+	}
+	if n.state != Follower {
+		log.Printf("Raft %s: stepping down in term %d", n.id, n.currentTerm)
+	}
+	n.state = Follower
+	n.resetElectionDeadline()
+}
+
+// startElection must be called with n.mu held
+func (n *Node) startElection() {
+	n.state = Candidate
+	n.currentTerm++
+	n.votedFor = n.id
+	n.leaderID = ""
+	n.votes = 1
+	n.resetElectionDeadline()
+
+	req := VoteRequest{
+		Term:         n.currentTerm,
+		CandidateID:  n.id,
+		LastLogIndex: n.lastIndex(),
+		LastLogTerm:  n.lastTerm(),
+	}
+	if n.votes*2 > n.clusterSize() {
+		n.becomeLeader()
+		return
+	}
+	for _, peer := range n.peers {
+		go n.requestVote(peer, req)
+	}
+}
+
+func (n *Node) requestVote(peer string, req VoteRequest) {
+	resp, err := n.transport.RequestVote(n.id, peer, req)
+	if err != nil {
+		return
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if resp.Term > n.currentTerm {
+		n.stepDown(resp.Term)
+		return
+	}
+	if n.state != Candidate || n.currentTerm != req.Term || !resp.Granted {
+		return
+	}
+	n.votes++
+	if n.votes*2 > n.clusterSize() {
+		n.becomeLeader()
+	}
+}
+
+// becomeLeader must be called with n.mu held
+func (n *Node) becomeLeader() {
+	n.state = Leader
+	n.leaderID = n.id
+	for _, peer := range n.peers {
+		n.nextIndex[peer] = n.lastIndex() + 1
+		n.matchIndex[peer] = 0
+	}
+	log.Printf("Raft %s: elected leader for term %d", n.id, n.currentTerm)
+
+	// Entries from earlier terms can only be committed indirectly, so start
+	// the term with a no-op that carries them along
+	n.entries = append(n.entries, Entry{Term: n.currentTerm, Index: n.lastIndex() + 1})
+	n.advanceCommit()
+	n.broadcastAppend()
+}
+
+// broadcastAppend sends each follower the entries it is missing, or an
+// empty heartbeat. Caller must hold n.mu.
+func (n *Node) broadcastAppend() {
+	n.lastHeartbeat = time.Now()
+	for _, peer := range n.peers {
+		next := n.nextIndex[peer]
+		if next < 1 {
+			next = 1
+		}
+		end := n.lastIndex() + 1
+		if end-next > maxAppendBatch {
+			end = next + maxAppendBatch
+		}
+		req := AppendRequest{
+			Term:         n.currentTerm,
+			LeaderID:     n.id,
+			PrevLogIndex: next - 1,
+			PrevLogTerm:  n.entries[next-1].Term,
+			Entries:      append([]Entry(nil), n.entries[next:end]...),
+			LeaderCommit: n.commitIndex,
+		}
+		go n.appendEntries(peer, req)
+	}
+}
+
+func (n *Node) appendEntries(peer string, req AppendRequest) {
+	resp, err := n.transport.AppendEntries(n.id, peer, req)
+	if err != nil {
+		return
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if resp.Term > n.currentTerm {
+		n.stepDown(resp.Term)
+		return
+	}
+	if n.state != Leader || n.currentTerm != req.Term {
+		return
+	}
+
+	if resp.Success {
+		match := req.PrevLogIndex + len(req.Entries)
+		if match > n.matchIndex[peer] {
+			n.matchIndex[peer] = match
+			n.nextIndex[peer] = match + 1
+			n.advanceCommit()
+		}
+		return
+	}
+
+	next := n.nextIndex[peer] - 1
+	if resp.LastLogIndex+1 < next {
+		next = resp.LastLogIndex + 1
+	}
+	if next < 1 {
+		next = 1
+	}
+	n.nextIndex[peer] = next
+}
+
+// advanceCommit commits the highest entry of the current term stored on a
+// majority. Caller must hold n.mu.
+func (n *Node) advanceCommit() {
+	for index := n.lastIndex(); index > n.commitIndex; index-- {
+		if n.entries[index].Term != n.currentTerm {
+			break
+		}
+		count := 1
+		for _, peer := range n.peers {
+			if n.matchIndex[peer] >= index {
+				count++
+			}
+		}
+		if count*2 > n.clusterSize() {
+			n.commitIndex = index
+			n.signalApply()
+			return
+		}
+	}
+}
+
+func (n *Node) HandleRequestVote(req VoteRequest) VoteResponse {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if req.Term < n.currentTerm {
+		return VoteResponse{Term: n.currentTerm}
+	}
+	if req.Term > n.currentTerm {
+		n.stepDown(req.Term)
+	}
+
+	upToDate := req.LastLogTerm > n.lastTerm() ||
+		(req.LastLogTerm == n.lastTerm() && req.LastLogIndex >= n.lastIndex())
+	if (n.votedFor == "" || n.votedFor == req.CandidateID) && upToDate {
+		n.votedFor = req.CandidateID
+		n.resetElectionDeadline()
+		return VoteResponse{Term: n.currentTerm, Granted: true}
+	}
+	return VoteResponse{Term: n.currentTerm}
+}
+
+func (n *Node) HandleAppendEntries(req AppendRequest) AppendResponse {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if req.Term < n.currentTerm {
+		return AppendResponse{Term: n.currentTerm, LastLogIndex: n.lastIndex()}
+	}
+	if req.Term > n.currentTerm || n.state != Follower {
+		n.stepDown(req.Term)
+	}
+	n.leaderID = req.LeaderID
+	n.resetElectionDeadline()
+
+	if req.PrevLogIndex > n.lastIndex() {
+		return AppendResponse{Term: n.currentTerm, LastLogIndex: n.lastIndex()}
+	}
+	if n.entries[req.PrevLogIndex].Term != req.PrevLogTerm {
+		// Skip back over the whole conflicting term in one round trip
+		conflictTerm := n.entries[req.PrevLogIndex].Term
+		index := req.PrevLogIndex - 1
+		for index > n.commitIndex && n.entries[index].Term == conflictTerm {
+			index--
+		}
+		return AppendResponse{Term: n.currentTerm, LastLogIndex: index}
+	}
+
+	for i, entry := range req.Entries {
+		index := req.PrevLogIndex + 1 + i
+		if index <= n.lastIndex() {
+			if n.entries[index].Term == entry.Term {
+				continue
+			}
+			n.entries = n.entries[:index]
+		}
+		n.entries = append(n.entries, req.Entries[i:]...)
+		break
+	}
+
+	// Only entries this request has confirmed match the leader can be committed
+	commit := req.LeaderCommit
+	if lastNew := req.PrevLogIndex + len(req.Entries); lastNew < commit {
+		commit = lastNew
+	}
+	if commit > n.commitIndex {
+		n.commitIndex = commit
+		n.signalApply()
+	}
+	return AppendResponse{Term: n.currentTerm, Success: true, LastLogIndex: n.lastIndex()}
+}
@@ -0,0 +1,427 @@
+package raft
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ivorytoast/replay78/engine"
+)
+
+const settleTimeout = 5 * time.Second
+
+// cluster is a set of nodes on one MemoryTransport, each recording the data
+// of the entries it applies
+type cluster struct {
+	transport *MemoryTransport
+	nodes     map[string]*Node
+
+	mu      sync.Mutex
+	applied map[string][]string
+}
+
+func newCluster(t *testing.T, size int) *cluster {
+	t.Helper()
+	c := &cluster{
+		transport: NewMemoryTransport(),
+		nodes:     make(map[string]*Node),
+		applied:   make(map[string][]string),
+	}
+	var ids []string
+	for i := 1; i <= size; i++ {
+		ids = append(ids, fmt.Sprintf("n%d", i))
+	}
+	for _, id := range ids {
+		var peers []string
+		for _, peer := range ids {
+			if peer != id {
+				peers = append(peers, peer)
+			}
+		}
+		node := NewNode(id, peers, c.transport, func(entry Entry) {
+			if entry.Data == "" {
+				return
+			}
+			c.mu.Lock()
+			c.applied[id] = append(c.applied[id], entry.Data)
+			c.mu.Unlock()
+		})
+		c.transport.Register(id, node)
+		c.nodes[id] = node
+	}
+	for _, node := range c.nodes {
+		node.Start()
+	}
+	t.Cleanup(func() {
+		for _, node := range c.nodes {
+			node.Stop()
+		}
+	})
+	return c
+}
+
+// leader waits for exactly one leader among the nodes not excluded
+func (c *cluster) leader(t *testing.T, excluded ...string) *Node {
+	t.Helper()
+	var leader *Node
+	eventually(t, "a single leader", func() bool {
+		leader = nil
+		leaders := 0
+		for id, node := range c.nodes {
+			if contains(excluded, id) || !node.IsLeader() {
+				continue
+			}
+			leaders++
+			leader = node
+		}
+		return leaders == 1
+	})
+	return leader
+}
+
+func (c *cluster) appliedBy(id string) []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]string(nil), c.applied[id]...)
+}
+
+// converged waits until every node has applied the same entries, at least
+// n of them, and returns them
+func (c *cluster) converged(t *testing.T, n int) []string {
+	t.Helper()
+	var want []string
+	eventually(t, "every node to apply the same entries", func() bool {
+		want = nil
+		for id := range c.nodes {
+			got := c.appliedBy(id)
+			if len(got) < n {
+				return false
+			}
+			if want == nil {
+				want = got
+			} else if strings.Join(got, ",") != strings.Join(want, ",") {
+				return false
+			}
+		}
+		return true
+	})
+	return want
+}
+
+// propose keeps proposing data to whoever leads until some node applies it
+func (c *cluster) propose(t *testing.T, data string) {
+	t.Helper()
+	deadline := time.Now().Add(settleTimeout)
+	for time.Now().Before(deadline) {
+		for _, node := range c.nodes {
+			if _, _, ok := node.Propose(data); !ok {
+				continue
+			}
+			for wait := time.Now().Add(500 * time.Millisecond); time.Now().Before(wait); time.Sleep(5 * time.Millisecond) {
+				if contains(c.appliedBy(node.ID()), data) {
+					return
+				}
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("%s was never committed", data)
+}
+
+func eventually(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(settleTimeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+func TestElection(t *testing.T) {
+	tests := []struct {
+		name string
+		size int
+	}{
+		{"single node", 1},
+		{"three nodes", 3},
+		{"five nodes", 5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newCluster(t, tt.size)
+			leader := c.leader(t)
+			_, term, _ := leader.Status()
+
+			// Every node follows the same leader in the same term
+			eventually(t, "every node to follow the leader", func() bool {
+				for _, node := range c.nodes {
+					state, nodeTerm, leaderID := node.Status()
+					if nodeTerm != term || leaderID != leader.ID() || (node != leader && state != Follower) {
+						return false
+					}
+				}
+				return true
+			})
+
+			index, _, ok := leader.Propose("x")
+			if !ok {
+				t.Fatal("leader refused a proposal")
+			}
+			eventually(t, "the proposal to commit", func() bool {
+				return leader.CommitIndex() >= index
+			})
+			if got := c.converged(t, 1); strings.Join(got, ",") != "x" {
+				t.Errorf("applied %v, want [x]", got)
+			}
+		})
+	}
+}
+
+func TestReelectionAfterLeaderLoss(t *testing.T) {
+	c := newCluster(t, 3)
+	old := c.leader(t)
+	_, oldTerm, _ := old.Status()
+
+	c.transport.Disconnect(old.ID())
+	leader := c.leader(t, old.ID())
+	if _, term, _ := leader.Status(); term <= oldTerm {
+		t.Errorf("new leader in term %d, want after %d", term, oldTerm)
+	}
+
+	c.transport.Reconnect(old.ID())
+	eventually(t, "the old leader to step down", func() bool {
+		return !old.IsLeader()
+	})
+}
+
+func TestLogRepairAfterPartition(t *testing.T) {
+	tests := []struct {
+		name      string
+		isolate   func(c *cluster, leader *Node) string
+		stranded  int // Entries proposed on the isolated side, never committed
+		committed int // Entries committed by the majority meanwhile
+	}{
+		{
+			name: "follower catches up",
+			isolate: func(c *cluster, leader *Node) string {
+				for id := range c.nodes {
+					if id != leader.ID() {
+						return id
+					}
+				}
+				return ""
+			},
+			committed: 5,
+		},
+		{
+			name:      "old leader drops its uncommitted entries",
+			isolate:   func(c *cluster, leader *Node) string { return leader.ID() },
+			stranded:  3,
+			committed: 5,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newCluster(t, 3)
+			c.propose(t, "before")
+			c.converged(t, 1)
+
+			isolated := tt.isolate(c, c.leader(t))
+			c.transport.Disconnect(isolated)
+			for i := 0; i < tt.stranded; i++ {
+				c.nodes[isolated].Propose(fmt.Sprintf("stranded-%d", i))
+			}
+
+			c.leader(t, isolated)
+			var want []string
+			for i := 0; i < tt.committed; i++ {
+				data := fmt.Sprintf("committed-%d", i)
+				c.propose(t, data)
+				want = append(want, data)
+			}
+			if got := c.appliedBy(isolated); len(got) != 1 {
+				t.Errorf("isolated node applied %v while cut off", got)
+			}
+
+			c.transport.Heal()
+			got := c.converged(t, 1+len(want))
+			if strings.Join(got, ",") != "before,"+strings.Join(want, ",") {
+				t.Errorf("applied %v after healing, want before then %v", got, want)
+			}
+		})
+	}
+}
+
+func TestCommitUnderMessageDrops(t *testing.T) {
+	tests := []struct {
+		name     string
+		dropRate float64
+	}{
+		{"ten percent", 0.1},
+		{"thirty percent", 0.3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newCluster(t, 3)
+			c.leader(t)
+			c.transport.SetDropRate(tt.dropRate)
+
+			var want []string
+			for i := 0; i < 10; i++ {
+				data := fmt.Sprintf("entry-%d", i)
+				c.propose(t, data)
+				want = append(want, data)
+			}
+
+			c.transport.SetDropRate(0)
+			got := c.converged(t, len(want))
+			for _, data := range want {
+				if !contains(got, data) {
+					t.Errorf("%s missing from the applied log %v", data, got)
+				}
+			}
+		})
+	}
+}
+
+func TestReplicaSingleNodeCommits(t *testing.T) {
+	transport := NewMemoryTransport()
+	e := engine.NewEngineWithSink(engine.NewMemorySink())
+	r := NewReplica("solo", nil, transport, e)
+	transport.Register("solo", r.Node())
+	e.Run()
+	r.Start()
+	t.Cleanup(r.Stop)
+
+	eventually(t, "the node to lead", r.Node().IsLeader)
+	result, err := e.SubmitWait(engine.Input{Line: "ttt|new|"})
+	if err != nil {
+		t.Fatalf("SubmitWait: %v", err)
+	}
+	if result.Seq == 0 {
+		t.Errorf("input was never sequenced: %+v", result)
+	}
+}
+
+func TestReplicaAppliesCommittedInputsWhenFull(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy engine.OverloadPolicy
+	}{
+		{"reject", engine.OverloadReject},
+		{"drop lowest", engine.OverloadDropLowest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			transport := NewMemoryTransport()
+			e := engine.NewEngineWithSink(engine.NewMemorySink())
+			e.SetQueueConfig(engine.QueueConfig{Capacity: 1, Policy: tt.policy})
+			r := NewReplica("solo", nil, transport, e)
+			transport.Register("solo", r.Node())
+			e.Run()
+			r.Start()
+			t.Cleanup(r.Stop)
+			eventually(t, "the node to lead", r.Node().IsLeader)
+
+			// Hold the engine loop in one inspection and fill the queue
+			// with another, so only a committed input can still get in
+			release := make(chan struct{})
+			running := make(chan struct{})
+			go e.Inspect(func() {
+				close(running)
+				<-release
+			})
+			<-running
+			inspected := make(chan error, 2)
+			for full := false; !full; {
+				go func() { inspected <- e.Inspect(func() {}) }()
+				select {
+				case err := <-inspected:
+					full = errors.Is(err, engine.ErrQueueFull)
+				case <-time.After(50 * time.Millisecond): // Queued behind the first
+				}
+			}
+
+			results := make(chan error, 1)
+			go func() {
+				_, err := e.SubmitWait(engine.Input{Line: "ttt|new|"})
+				results <- err
+			}()
+			time.Sleep(100 * time.Millisecond) // Let the entry commit
+			close(release)
+
+			select {
+			case err := <-results:
+				if err != nil {
+					t.Errorf("SubmitWait = %v, want the committed input applied", err)
+				}
+			case <-time.After(settleTimeout):
+				t.Fatal("committed input was never applied")
+			}
+		})
+	}
+}
+
+func TestReplicaForgetsTimedOutProposals(t *testing.T) {
+	transport := NewMemoryTransport()
+	ids := []string{"a", "b", "c"}
+	replicas := make(map[string]*Replica)
+	for _, id := range ids {
+		var peers []string
+		for _, peer := range ids {
+			if peer != id {
+				peers = append(peers, peer)
+			}
+		}
+		e := engine.NewEngineWithSink(engine.NewMemorySink())
+		r := NewReplica(id, peers, transport, e)
+		transport.Register(id, r.Node())
+		e.Run()
+		r.Start()
+		t.Cleanup(r.Stop)
+		replicas[id] = r
+	}
+
+	var leader *Replica
+	eventually(t, "a leader", func() bool {
+		for _, r := range replicas {
+			if r.Node().IsLeader() {
+				leader = r
+				return true
+			}
+		}
+		return false
+	})
+
+	// Cut the leader off so nothing it proposes can commit
+	for _, id := range ids {
+		if id != leader.Node().ID() {
+			transport.Partition(leader.Node().ID(), id)
+		}
+	}
+	_, err := leader.Sequence(engine.Input{Line: "ttt|new|"}, true)
+	if !errors.Is(err, ErrProposalTimeout) && !errors.Is(err, ErrNotLeader) {
+		t.Fatalf("Sequence = %v, want a timeout", err)
+	}
+
+	leader.mu.Lock()
+	pending := len(leader.pending)
+	leader.mu.Unlock()
+	if pending != 0 {
+		t.Errorf("%d proposals still pending after timing out", pending)
+	}
+}
@@ -0,0 +1,127 @@
+package raft
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/ivorytoast/replay78/engine"
+)
+
+const proposalTimeout = 2 * time.Second
+
+var (
+	ErrNotLeader       = errors.New("raft: not the leader")
+	ErrProposalLost    = errors.New("raft: proposal was overwritten by a new leader")
+	ErrProposalTimeout = errors.New("raft: proposal not committed in time")
+)
+
+// Replica is a replay78 node whose engine only ever sees inputs that the
+// cluster has committed, in commit order. Every replica's engine therefore
+// processes the same inputs in the same order and writes the same log.
+type Replica struct {
+	node   *Node
+	engine *engine.Engine
+
+	mu      sync.Mutex
+	pending map[int]proposal // By log index
+}
+
+type proposal struct {
+	term  int
+	reply chan proposalResult
+}
+
+type proposalResult struct {
+	result engine.Result
+	err    error
+}
+
+// NewReplica wires e to a new cluster node. e is put in replay mode since
+// the order now comes from the replicated log, which also means generators
+// are not started on replicas. Call it before e.Run.
+func NewReplica(id string, peers []string, transport Transport, e *engine.Engine) *Replica {
+	r := &Replica{
+		engine:  e,
+		pending: make(map[int]proposal),
+	}
+	r.node = NewNode(id, peers, transport, r.apply)
+	e.SetReplayMode()
+	e.SetSequencer(r)
+	return r
+}
+
+func (r *Replica) Node() *Node {
+	return r.node
+}
+
+func (r *Replica) Engine() *engine.Engine {
+	return r.engine
+}
+
+func (r *Replica) Start() {
+	r.node.Start()
+}
+
+func (r *Replica) Stop() {
+	r.node.Stop()
+}
+
+// Sequence proposes the input to the cluster. Only the leader accepts
+// proposals, other replicas return ErrNotLeader naming the current leader.
+func (r *Replica) Sequence(input engine.Input, wait bool) (engine.Result, error) {
+	r.mu.Lock()
+	index, term, ok := r.node.Propose(input.Encode())
+	if !ok {
+		r.mu.Unlock()
+		_, _, leader := r.node.Status()
+		return engine.Result{}, fmt.Errorf("%w (leader: %q)", ErrNotLeader, leader)
+	}
+	if !wait {
+		r.mu.Unlock()
+		return engine.Result{}, nil
+	}
+	reply := make(chan proposalResult, 1)
+	r.pending[index] = proposal{term: term, reply: reply}
+	r.mu.Unlock()
+
+	select {
+	case res := <-reply:
+		return res.result, res.err
+	case <-time.After(proposalTimeout):
+		// A later proposal may have taken the index over since
+		r.mu.Lock()
+		if p, ok := r.pending[index]; ok && p.reply == reply {
+			delete(r.pending, index)
+		}
+		r.mu.Unlock()
+		return engine.Result{}, ErrProposalTimeout
+	}
+}
+
+// apply runs on the node's apply goroutine for each committed entry. Deliver
+// never refuses a committed input, so a full queue cannot make this replica
+// skip an entry the others apply.
+func (r *Replica) apply(entry Entry) {
+	var res proposalResult
+	if entry.Data != "" {
+		if input, ok := engine.DecodeInput(entry.Data); ok {
+			res.result, res.err = r.engine.Deliver(input)
+		} else {
+			res.err = fmt.Errorf("raft: undecodable entry %d: %q", entry.Index, entry.Data)
+		}
+	}
+
+	r.mu.Lock()
+	p, ok := r.pending[entry.Index]
+	delete(r.pending, entry.Index)
+	r.mu.Unlock()
+	if !ok {
+		return
+	}
+	if p.term != entry.Term {
+		res = proposalResult{err: ErrProposalLost}
+	}
+	p.reply <- res
+}
@@ -0,0 +1,217 @@
+package raft
+
+import (
+	"encoding/json"
+	"errors"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+)
+
+var ErrUnreachable = errors.New("raft: peer unreachable")
+
+// MemoryTransport connects nodes in the same process and can inject faults:
+// disconnected nodes, cut links, dropped messages and latency.
+type MemoryTransport struct {
+	mu       sync.Mutex
+	handlers map[string]Handler
+	down     map[string]bool
+	cut      map[[2]string]bool
+	dropRate float64
+	delay    time.Duration
+	rng      *rand.Rand
+}
+
+func NewMemoryTransport() *MemoryTransport {
+	return &MemoryTransport{
+		handlers: make(map[string]Handler),
+		down:     make(map[string]bool),
+		cut:      make(map[[2]string]bool),
+		rng:      rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+func (t *MemoryTransport) Register(id string, h Handler) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.handlers[id] = h
+}
+
+// Disconnect isolates a node from everyone until Reconnect
+func (t *MemoryTransport) Disconnect(id string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.down[id] = true
+}
+
+func (t *MemoryTransport) Reconnect(id string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.down, id)
+}
+
+// Partition cuts the link between a and b in both directions
+func (t *MemoryTransport) Partition(a, b string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.cut[[2]string{a, b}] = true
+	t.cut[[2]string{b, a}] = true
+}
+
+// Heal restores every disconnected node and cut link
+func (t *MemoryTransport) Heal() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.down = make(map[string]bool)
+	t.cut = make(map[[2]string]bool)
+}
+
+// SetDropRate makes each message fail with probability p
+func (t *MemoryTransport) SetDropRate(p float64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.dropRate = p
+}
+
+func (t *MemoryTransport) SetDelay(d time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.delay = d
+}
+
+func (t *MemoryTransport) route(from, to string) (Handler, error) {
+	t.mu.Lock()
+	h, ok := t.handlers[to]
+	blocked := t.down[from] || t.down[to] || t.cut[[2]string{from, to}]
+	dropped := t.dropRate > 0 && t.rng.Float64() < t.dropRate
+	delay := t.delay
+	t.mu.Unlock()
+
+	if !ok || blocked || dropped {
+		return nil, ErrUnreachable
+	}
+	if delay > 0 {
+		time.Sleep(delay)
+	}
+	return h, nil
+}
+
+func (t *MemoryTransport) RequestVote(from, to string, req VoteRequest) (VoteResponse, error) {
+	h, err := t.route(from, to)
+	if err != nil {
+		return VoteResponse{}, err
+	}
+	resp := h.HandleRequestVote(req)
+	// The reply travels back over the same link
+	if _, err := t.route(to, from); err != nil {
+		return VoteResponse{}, err
+	}
+	return resp, nil
+}
+
+func (t *MemoryTransport) AppendEntries(from, to string, req AppendRequest) (AppendResponse, error) {
+	h, err := t.route(from, to)
+	if err != nil {
+		return AppendResponse{}, err
+	}
+	resp := h.HandleAppendEntries(req)
+	if _, err := t.route(to, from); err != nil {
+		return AppendResponse{}, err
+	}
+	return resp, nil
+}
+
+const tcpTimeout = 200 * time.Millisecond
+
+// TCPTransport sends each RPC as one JSON request and response over a
+// fresh TCP connection
+type TCPTransport struct {
+	Addrs map[string]string // Node id to host:port
+}
+
+type rpcRequest struct {
+	Vote   *VoteRequest   `json:"vote,omitempty"`
+	Append *AppendRequest `json:"append,omitempty"`
+}
+
+type rpcResponse struct {
+	Vote   *VoteResponse   `json:"vote,omitempty"`
+	Append *AppendResponse `json:"append,omitempty"`
+}
+
+func (t *TCPTransport) call(to string, req rpcRequest) (rpcResponse, error) {
+	var resp rpcResponse
+	addr, ok := t.Addrs[to]
+	if !ok {
+		return resp, ErrUnreachable
+	}
+	conn, err := net.DialTimeout("tcp", addr, tcpTimeout)
+	if err != nil {
+		return resp, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(tcpTimeout))
+
+	if err := json.NewEncoder(conn).Encode(req); err != nil {
+		return resp, err
+	}
+	err = json.NewDecoder(conn).Decode(&resp)
+	return resp, err
+}
+
+func (t *TCPTransport) RequestVote(from, to string, req VoteRequest) (VoteResponse, error) {
+	resp, err := t.call(to, rpcRequest{Vote: &req})
+	if err != nil || resp.Vote == nil {
+		return VoteResponse{}, ErrUnreachable
+	}
+	return *resp.Vote, nil
+}
+
+func (t *TCPTransport) AppendEntries(from, to string, req AppendRequest) (AppendResponse, error) {
+	resp, err := t.call(to, rpcRequest{Append: &req})
+	if err != nil || resp.Append == nil {
+		return AppendResponse{}, ErrUnreachable
+	}
+	return *resp.Append, nil
+}
+
+// ServeTCP answers RPCs for h on addr until the listener is closed
+func ServeTCP(addr string, h Handler) (net.Listener, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go serveRPC(conn, h)
+		}
+	}()
+	return listener, nil
+}
+
+func serveRPC(conn net.Conn, h Handler) {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(tcpTimeout))
+
+	var req rpcRequest
+	if err := json.NewDecoder(conn).Decode(&req); err != nil {
+		return
+	}
+	var resp rpcResponse
+	switch {
+	case req.Vote != nil:
+		vote := h.HandleRequestVote(*req.Vote)
+		resp.Vote = &vote
+	case req.Append != nil:
+		appended := h.HandleAppendEntries(*req.Append)
+		resp.Append = &appended
+	default:
+		return
+	}
+	json.NewEncoder(conn).Encode(resp)
+}
@@ -0,0 +1,138 @@
+package engine
+
+import (
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Input is a single command submitted to the engine
+type Input struct {
+	Line     string   // topic|action|payload
+	Priority Priority // Lane to queue on, PriorityDefault picks it from the topic
+	Source   Source
+	Key      string // Optional idempotency key, repeats are not re-applied
+
+	Partition string // Game the input belongs to, and the shard.Runtime routing key
+	GlobalSeq int    // Arrival order across all shards, set by shard.Runtime
+
+	Offset int64 // Byte offset just past this line in a tailed file
+}
+
+// Source describes who or what submitted an input
+type Source struct {
+	Kind string // cli, ws, file, gen, conn, ...
+	ID   string // Connection id, generator name, file path
+	User string
+}
+
+// String renders the source as "kind:id", or just "kind" without an id
+func (s Source) String() string {
+	if s.ID == "" {
+		return s.Kind
+	}
+	return s.Kind + ":" + s.ID
+}
+
+func ParseSource(s string) Source {
+	kind, id, _ := strings.Cut(s, ":")
+	return Source{Kind: kind, ID: id}
+}
+
+// Input records are written as "seq|I|topic|action|payload". Any attributes
+// an input carries are appended to the kind, e.g. "seq|I?src=ws:3&user=bob|...".
+func inputKind(input Input, topicPriority Priority) string {
+	var attrs []string
+	if input.Priority != PriorityDefault && input.Priority != topicPriority {
+		attrs = append(attrs, "lane="+escapeAttr(input.Priority.String()))
+	}
+	if input.Source.Kind != "" {
+		attrs = append(attrs, "src="+escapeAttr(input.Source.String()))
+	}
+	if input.Source.User != "" {
+		attrs = append(attrs, "user="+escapeAttr(input.Source.User))
+	}
+	if input.Key != "" {
+		attrs = append(attrs, "key="+escapeAttr(input.Key))
+	}
+	if input.Partition != "" {
+		attrs = append(attrs, "part="+escapeAttr(input.Partition))
+	}
+	if input.GlobalSeq != 0 {
+		attrs = append(attrs, "gseq="+strconv.Itoa(input.GlobalSeq))
+	}
+	if input.Offset != 0 {
+		attrs = append(attrs, "off="+strconv.FormatInt(input.Offset, 10))
+	}
+	if len(attrs) == 0 {
+		return "I"
+	}
+	return "I?" + strings.Join(attrs, "&")
+}
+
+// Encode renders the input the way it appears in an I record, without the
+// seq, so it can be carried elsewhere and restored with DecodeInput
+func (input Input) Encode() string {
+	return inputKind(input, PriorityDefault) + "|" + input.Line
+}
+
+func DecodeInput(s string) (Input, bool) {
+	kind, body, ok := strings.Cut(s, "|")
+	if !ok {
+		return Input{}, false
+	}
+	return InputFromRecord(kind, body)
+}
+
+// InputFromRecord rebuilds the Input that produced an I record, given the
+// record's kind field and the remaining topic|action|payload text
+func InputFromRecord(kind, body string) (Input, bool) {
+	if kind != "I" && !strings.HasPrefix(kind, "I?") {
+		return Input{}, false
+	}
+	input := Input{Line: body}
+	for key, value := range parseAttrs(strings.TrimPrefix(kind[1:], "?")) {
+		switch key {
+		case "lane":
+			input.Priority = ParsePriority(value)
+		case "src":
+			user := input.Source.User
+			input.Source = ParseSource(value)
+			input.Source.User = user
+		case "user":
+			input.Source.User = value
+		case "key":
+			input.Key = value
+		case "part":
+			input.Partition = value
+		case "gseq":
+			input.GlobalSeq, _ = strconv.Atoi(value)
+		case "off":
+			input.Offset, _ = strconv.ParseInt(value, 10, 64)
+		}
+	}
+	return input, true
+}
+
+func parseAttrs(s string) map[string]string {
+	attrs := make(map[string]string)
+	if s == "" {
+		return attrs
+	}
+	for _, pair := range strings.Split(s, "&") {
+		key, value, _ := strings.Cut(pair, "=")
+		if unescaped, err := url.PathUnescape(value); err == nil {
+			value = unescaped
+		}
+		attrs[key] = value
+	}
+	return attrs
+}
+
+var attrEscaper = strings.NewReplacer("%", "%25", "|", "%7C", "&", "%26", "=", "%3D", "\n", "%0A")
+
+// escapeAttr keeps attribute values from breaking the record or attribute
+// separators while leaving ordinary text readable
+func escapeAttr(value string) string {
+	return attrEscaper.Replace(value)
+}
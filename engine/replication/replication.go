@@ -0,0 +1,320 @@
+// Package replication streams an engine's log to hot-standby followers over
+// TCP. A follower connects with "FROM <seq>\n", receives every record from
+// that seq onwards (first from the leader's log file, then live), applies
+// the I records to its own engine and checks that it produced the same
+// record at every seq.
+package replication
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/ivorytoast/replay78/engine"
+	"github.com/ivorytoast/replay78/engine/logreader"
+)
+
+const streamBuffer = 4096
+
+type Leader struct {
+	engine   *engine.Engine
+	listener net.Listener
+
+	mu      sync.Mutex
+	streams map[*stream]bool
+}
+
+type stream struct {
+	conn    net.Conn
+	records chan string
+	done    chan struct{}
+}
+
+// NewLeader starts serving e's log on addr, adding itself to e's log sink
+func NewLeader(e *engine.Engine, addr string) (*Leader, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	l := &Leader{
+		engine:   e,
+		listener: listener,
+		streams:  make(map[*stream]bool),
+	}
+	e.SetLogSink(engine.NewMultiSink(e.LogSink(), l))
+	go l.accept()
+	log.Printf("Replication leader listening on %s", listener.Addr())
+	return l, nil
+}
+
+func (l *Leader) Addr() net.Addr {
+	return l.listener.Addr()
+}
+
+func (l *Leader) Close() error {
+	l.mu.Lock()
+	for s := range l.streams {
+		l.drop(s)
+	}
+	l.mu.Unlock()
+	return l.listener.Close()
+}
+
+func (l *Leader) accept() {
+	for {
+		conn, err := l.listener.Accept()
+		if err != nil {
+			return
+		}
+		go l.serve(conn)
+	}
+}
+
+// Write makes the leader a LogSink, which NewLeader tees into e's log. It
+// runs on the engine loop, so a follower that cannot keep up is
+// disconnected rather than allowed to stall the leader.
+func (l *Leader) Write(record string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for s := range l.streams {
+		select {
+		case s.records <- record:
+		default:
+			log.Printf("Replication follower %s too slow, disconnecting", s.conn.RemoteAddr())
+			l.drop(s)
+		}
+	}
+	return nil
+}
+
+// drop removes a stream. Caller must hold l.mu.
+func (l *Leader) drop(s *stream) {
+	if !l.streams[s] {
+		return
+	}
+	delete(l.streams, s)
+	close(s.done)
+	s.conn.Close()
+}
+
+func (l *Leader) serve(conn net.Conn) {
+	reader := bufio.NewReader(conn)
+	hello, err := reader.ReadString('\n')
+	if err != nil {
+		conn.Close()
+		return
+	}
+	var from int
+	if _, err := fmt.Sscanf(hello, "FROM %d", &from); err != nil {
+		fmt.Fprintf(conn, "ERR expected FROM <seq>\n")
+		conn.Close()
+		return
+	}
+
+	// Register before reading the log so nothing written meanwhile is missed
+	s := &stream{
+		conn:    conn,
+		records: make(chan string, streamBuffer),
+		done:    make(chan struct{}),
+	}
+	l.mu.Lock()
+	l.streams[s] = true
+	l.mu.Unlock()
+	defer func() {
+		l.mu.Lock()
+		l.drop(s)
+		l.mu.Unlock()
+	}()
+	log.Printf("Replication follower %s connected from seq %d", conn.RemoteAddr(), from)
+
+	writer := bufio.NewWriter(conn)
+	last := from - 1
+	send := func(record string) error {
+		seq := recordSeq(record)
+		if seq <= last {
+			return nil
+		}
+		last = seq
+		_, err := writer.WriteString(record + "\n")
+		return err
+	}
+
+	history, err := logreader.Open(l.engine.LogFile())
+	if err == nil {
+		defer history.Close()
+		err = history.Seek(from)
+	}
+	if err != nil {
+		log.Printf("Replication catch-up failed: %v", err)
+		return
+	}
+	for history.Next() {
+		if err := send(history.Record().Raw); err != nil {
+			return
+		}
+	}
+	if err := history.Err(); err != nil {
+		log.Printf("Replication catch-up failed: %v", err)
+		return
+	}
+	if err := writer.Flush(); err != nil {
+		return
+	}
+
+	for {
+		select {
+		case record := <-s.records:
+			if err := send(record); err != nil {
+				return
+			}
+			if len(s.records) == 0 {
+				if err := writer.Flush(); err != nil {
+					return
+				}
+			}
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// Follower applies a leader's inputs to its own engine, which must be in
+// replay mode so inputs are processed exactly in the order received.
+type Follower struct {
+	engine *engine.Engine
+
+	mu      sync.Mutex
+	records map[int]string // Own records not yet checked against the leader
+
+	lastSeq    int
+	mismatches int
+	promoted   bool
+}
+
+// NewFollower attaches to e. Call it before e.Run.
+func NewFollower(e *engine.Engine) *Follower {
+	f := &Follower{
+		engine:  e,
+		records: make(map[int]string),
+	}
+	e.OnRecord(f.record)
+	return f
+}
+
+func (f *Follower) record(line string) {
+	f.mu.Lock()
+	if !f.promoted {
+		f.records[recordSeq(line)] = line
+	}
+	f.mu.Unlock()
+}
+
+// LastSeq is the last leader seq this follower has applied and checked
+func (f *Follower) LastSeq() int {
+	return f.lastSeq
+}
+
+func (f *Follower) Mismatches() int {
+	return f.mismatches
+}
+
+// Follow streams from the leader at addr until the connection is lost. It
+// always returns an error, io.EOF if the leader closed the stream.
+func (f *Follower) Follow(addr string) error {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if _, err := fmt.Fprintf(conn, "FROM %d\n", f.lastSeq+1); err != nil {
+		return err
+	}
+	log.Printf("Following leader %s from seq %d", addr, f.lastSeq+1)
+
+	scanner := bufio.NewScanner(conn)
+	for scanner.Scan() {
+		if err := f.apply(scanner.Text()); err != nil {
+			return err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return io.EOF
+}
+
+func (f *Follower) apply(line string) error {
+	parts := strings.SplitN(line, "|", 3)
+	if len(parts) < 3 {
+		return fmt.Errorf("malformed record from leader: %q", line)
+	}
+	seq, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return fmt.Errorf("malformed record from leader: %q", line)
+	}
+
+	if input, ok := engine.InputFromRecord(parts[1], parts[2]); ok {
+		if _, err := f.engine.SubmitWait(input); err != nil {
+			return err
+		}
+	} else if badLine, ok := strings.CutPrefix(parts[2], "Bad Input: "); ok && !f.has(seq) {
+		// Unparseable inputs are never logged as I records, only as this
+		// output, so feed the raw line in again to reproduce it
+		if _, err := f.engine.SubmitWait(engine.Input{Line: badLine}); err != nil {
+			return err
+		}
+	}
+
+	f.verify(seq, line)
+	f.lastSeq = seq
+	return nil
+}
+
+func (f *Follower) has(seq int) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	_, ok := f.records[seq]
+	return ok
+}
+
+func (f *Follower) verify(seq int, leaderRecord string) {
+	f.mu.Lock()
+	own, ok := f.records[seq]
+	delete(f.records, seq)
+	f.mu.Unlock()
+
+	if !ok || own != leaderRecord {
+		f.mismatches++
+		log.Printf("Replication mismatch at seq %d: leader %q, follower %q", seq, leaderRecord, own)
+	}
+}
+
+// Promote stops treating the engine as a replica and starts serving its log
+// to new followers on addr. Call it once Follow has returned.
+func (f *Follower) Promote(addr string) (*Leader, error) {
+	f.mu.Lock()
+	f.promoted = true
+	f.records = nil
+	f.mu.Unlock()
+
+	if err := f.engine.EndReplayMode(); err != nil {
+		return nil, err
+	}
+	log.Printf("Promoted to leader at seq %d", f.lastSeq)
+	if addr == "" {
+		return nil, nil
+	}
+	return NewLeader(f.engine, addr)
+}
+
+func recordSeq(record string) int {
+	seq, _, _ := strings.Cut(record, "|")
+	n, _ := strconv.Atoi(seq)
+	return n
+}
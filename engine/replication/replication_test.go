@@ -0,0 +1,223 @@
+package replication
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ivorytoast/replay78/apps"
+	"github.com/ivorytoast/replay78/engine"
+)
+
+const syncTimeout = 5 * time.Second
+
+// newTestEngine logs to a file in a temporary directory, since leaders
+// serve catch-up from their log file
+func newTestEngine(t *testing.T, name string, withApp bool) *engine.Engine {
+	t.Helper()
+	e := engine.NewEngineWithLogFile(filepath.Join(t.TempDir(), name+".log"))
+	e.DisableGenerator("tick")
+	if withApp {
+		e.RegisterApplication(apps.NewTicTacToeApp(e))
+	}
+	t.Cleanup(func() { e.Close() })
+	return e
+}
+
+func newTestLeader(t *testing.T, e *engine.Engine) *Leader {
+	t.Helper()
+	l, err := NewLeader(e, "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	return l
+}
+
+func newTestFollower(t *testing.T, withApp bool) (*Follower, *engine.Engine) {
+	t.Helper()
+	e := newTestEngine(t, "follower", withApp)
+	e.SetReplayMode()
+	f := NewFollower(e)
+	e.Run()
+	return f, e
+}
+
+// follow runs f.Follow in the background. The returned channel receives
+// its error once the stream ends.
+func follow(f *Follower, l *Leader) <-chan error {
+	done := make(chan error, 1)
+	go func() { done <- f.Follow(l.Addr().String()) }()
+	return done
+}
+
+// stop closes l and waits for the follower to notice
+func stop(t *testing.T, l *Leader, done <-chan error) {
+	t.Helper()
+	l.Close()
+	select {
+	case err := <-done:
+		if !errors.Is(err, io.EOF) {
+			t.Errorf("Follow = %v, want io.EOF", err)
+		}
+	case <-time.After(syncTimeout):
+		t.Fatal("Follow did not return after the leader closed")
+	}
+}
+
+func submitAll(t *testing.T, e *engine.Engine, lines ...string) {
+	t.Helper()
+	for _, line := range lines {
+		if _, err := e.SubmitWait(engine.Input{Line: line}); err != nil {
+			t.Fatalf("SubmitWait(%s): %v", line, err)
+		}
+	}
+}
+
+func readLog(t *testing.T, e *engine.Engine) []string {
+	t.Helper()
+	data, err := os.ReadFile(e.LogFile())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+}
+
+// waitForLog waits until follower has logged as many records as leader
+func waitForLog(t *testing.T, leader, follower *engine.Engine) {
+	t.Helper()
+	deadline := time.Now().Add(syncTimeout)
+	for len(readLog(t, follower)) < len(readLog(t, leader)) {
+		if time.Now().After(deadline) {
+			t.Fatalf("follower logged %d records, leader %d", len(readLog(t, follower)), len(readLog(t, leader)))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func assertSameLog(t *testing.T, leader, follower *engine.Engine) {
+	t.Helper()
+	if got, want := readLog(t, follower), readLog(t, leader); !slices.Equal(got, want) {
+		t.Errorf("follower log:\n%s\nleader log:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestFollowerStreamsLeaderLog(t *testing.T) {
+	le := newTestEngine(t, "leader", true)
+	l := newTestLeader(t, le)
+	le.Run()
+	f, fe := newTestFollower(t, true)
+
+	// Logged before the follower connects, so served from the log file
+	submitAll(t, le, "ttt|new|", "ttt|move|0 0 0 0", "garbage")
+	done := follow(f, l)
+	// Streamed live
+	submitAll(t, le, "ttt|move|1 1 1 1", "ttt|show|")
+	waitForLog(t, le, fe)
+	stop(t, l, done)
+
+	assertSameLog(t, le, fe)
+	if got := f.Mismatches(); got != 0 {
+		t.Errorf("Mismatches = %d, want 0", got)
+	}
+	if got, want := f.LastSeq(), len(readLog(t, le)); got != want {
+		t.Errorf("LastSeq = %d, want %d", got, want)
+	}
+}
+
+func TestFollowerCatchesUpAfterReconnect(t *testing.T) {
+	le := newTestEngine(t, "leader", true)
+	l := newTestLeader(t, le)
+	le.Run()
+	f, fe := newTestFollower(t, true)
+
+	submitAll(t, le, "ttt|new|", "ttt|move|0 0 0 0")
+	done := follow(f, l)
+	waitForLog(t, le, fe)
+	stop(t, l, done)
+	before := f.LastSeq()
+
+	// Missed while disconnected, so the follower must resume after before
+	// rather than replay the start of the log again
+	submitAll(t, le, "ttt|move|1 1 1 1", "ttt|show|")
+	l = newTestLeader(t, le)
+	done = follow(f, l)
+	waitForLog(t, le, fe)
+	stop(t, l, done)
+
+	assertSameLog(t, le, fe)
+	if got := f.Mismatches(); got != 0 {
+		t.Errorf("Mismatches = %d, want 0", got)
+	}
+	if got := f.LastSeq(); got <= before {
+		t.Errorf("LastSeq = %d after reconnecting, want past %d", got, before)
+	}
+}
+
+func TestFollowerCountsMismatches(t *testing.T) {
+	le := newTestEngine(t, "leader", true)
+	l := newTestLeader(t, le)
+	le.Run()
+	// Without the application every ttt input produces different outputs
+	f, fe := newTestFollower(t, false)
+
+	submitAll(t, le, "ttt|new|", "ttt|show|")
+	done := follow(f, l)
+	deadline := time.Now().Add(syncTimeout)
+	for len(readLog(t, fe)) < 2 {
+		if time.Now().After(deadline) {
+			t.Fatal("follower never applied the leader's inputs")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	stop(t, l, done)
+
+	if f.Mismatches() == 0 {
+		t.Error("Mismatches = 0, want the diverging outputs counted")
+	}
+	if got, want := f.LastSeq(), len(readLog(t, le)); got != want {
+		t.Errorf("LastSeq = %d, want %d: a mismatch must not stop the stream", got, want)
+	}
+}
+
+func TestPromotedFollowerServesFollowers(t *testing.T) {
+	le := newTestEngine(t, "leader", true)
+	l := newTestLeader(t, le)
+	le.Run()
+	f, fe := newTestFollower(t, true)
+
+	submitAll(t, le, "ttt|new|", "ttt|move|0 0 0 0")
+	done := follow(f, l)
+	waitForLog(t, le, fe)
+	stop(t, l, done)
+
+	promoted, err := f.Promote("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Promote: %v", err)
+	}
+	t.Cleanup(func() { promoted.Close() })
+
+	// The promoted node takes inputs itself and continues the seqs
+	result, err := fe.SubmitWait(engine.Input{Line: "ttt|move|1 1 1 1"})
+	if err != nil {
+		t.Fatalf("SubmitWait after promotion: %v", err)
+	}
+	if want := f.LastSeq() + 1; result.Seq != want {
+		t.Errorf("first seq after promotion = %d, want %d", result.Seq, want)
+	}
+
+	next, ne := newTestFollower(t, true)
+	done = follow(next, promoted)
+	waitForLog(t, fe, ne)
+	stop(t, promoted, done)
+
+	assertSameLog(t, fe, ne)
+	if got := next.Mismatches(); got != 0 {
+		t.Errorf("Mismatches = %d, want 0", got)
+	}
+}
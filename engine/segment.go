@@ -0,0 +1,54 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"os"
+)
+
+// A run's log starts in its log file and continues in numbered segments
+// after every rotation: 78-5.log, 78-5.log.2, 78-5.log.3, ...
+func segmentPath(logFile string, segment int) string {
+	if segment <= 1 {
+		return logFile
+	}
+	return fmt.Sprintf("%s.%d", logFile, segment)
+}
+
+// LogSegments returns the files holding a run's log, oldest first
+func LogSegments(logFile string) []string {
+	var segments []string
+	for i := 1; ; i++ {
+		path := segmentPath(logFile, i)
+		if _, err := os.Stat(path); err != nil {
+			return segments
+		}
+		segments = append(segments, path)
+	}
+}
+
+// LogSegments returns the files this engine has written its log to so far
+func (e *Engine) LogSegments() []string {
+	return LogSegments(e.logFile)
+}
+
+var errNoRotation = errors.New("log sink does not support rotation")
+
+// rotateLog continues the log in a new segment, returning its number
+func (e *Engine) rotateLog() (int, error) {
+	e.sinkMu.Lock()
+	defer e.sinkMu.Unlock()
+	r, ok := e.sink.(rotatingSink)
+	if !ok {
+		return 0, errNoRotation
+	}
+	return r.Rotate()
+}
+
+// renameSegments moves every segment of a run's log along with its first file
+func renameSegments(from, to string) {
+	segments := LogSegments(from)
+	for i, path := range segments {
+		os.Rename(path, segmentPath(to, i+1))
+	}
+}
@@ -0,0 +1,250 @@
+// Package shard partitions inputs across several engines by key, e.g. a game
+// id. Each shard has its own log and keeps one game per partition, so
+// independent games run in parallel while every shard stays deterministic on
+// its own. Every input is stamped with its global arrival number, which is
+// what MergedLog interleaves the shards by.
+package shard
+
+import (
+	"fmt"
+	"hash/fnv"
+	"io"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ivorytoast/replay78/engine"
+	"github.com/ivorytoast/replay78/engine/logdiff"
+	"github.com/ivorytoast/replay78/engine/logreader"
+)
+
+const tickInterval = 1 * time.Second
+
+type Runtime struct {
+	shards   []*engine.Engine
+	logFiles []string
+	setup    func(e *engine.Engine)
+
+	mu        sync.Mutex
+	globalSeq int
+
+	ticker *time.Ticker // Set by Run
+	done   chan struct{}
+	once   sync.Once
+}
+
+// NewRuntime creates n engines logging to "<logBase>-shard<i>.log". setup
+// is called for every shard to register its applications. The shards' own
+// tick generators are disabled: the runtime ticks every shard itself, so
+// ticks are stamped like any other input.
+func NewRuntime(logBase string, n int, setup func(e *engine.Engine)) *Runtime {
+	r := &Runtime{setup: setup, done: make(chan struct{})}
+	for i := 0; i < n; i++ {
+		logFile := fmt.Sprintf("%s-shard%d.log", logBase, i)
+		e := engine.NewEngineWithLogFile(logFile)
+		r.setupShard(e)
+		r.shards = append(r.shards, e)
+		r.logFiles = append(r.logFiles, logFile)
+	}
+	return r
+}
+
+// setupShard prepares a shard, or a replay of one, so that both report the
+// same generators to engine commands
+func (r *Runtime) setupShard(e *engine.Engine) {
+	e.DisableGenerator("tick")
+	r.setup(e)
+}
+
+func (r *Runtime) Run() {
+	for _, e := range r.shards {
+		e.Run()
+	}
+	r.ticker = time.NewTicker(tickInterval)
+	go r.tick()
+}
+
+// Close stops the ticks and closes every shard's log
+func (r *Runtime) Close() error {
+	r.once.Do(func() {
+		close(r.done)
+		if r.ticker != nil {
+			r.ticker.Stop()
+		}
+	})
+	var firstErr error
+	for _, e := range r.shards {
+		if err := e.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// tick sends the same clock reading to every shard under one global
+// arrival number, so the merged log shows it as a single event
+func (r *Runtime) tick() {
+	for {
+		select {
+		case <-r.ticker.C:
+		case <-r.done:
+			return
+		}
+		input := engine.Input{
+			Line:   "tick|tock|" + strconv.FormatInt(time.Now().UTC().UnixNano(), 10),
+			Source: engine.Source{Kind: "gen", ID: "tick"},
+		}
+		input.GlobalSeq = r.nextGlobalSeq()
+		for _, e := range r.shards {
+			if err := e.Submit(input); err != nil {
+				log.Printf("Shard tick rejected: %v", err)
+			}
+		}
+	}
+}
+
+func (r *Runtime) Shards() int {
+	return len(r.shards)
+}
+
+func (r *Runtime) Shard(i int) *engine.Engine {
+	return r.shards[i]
+}
+
+func (r *Runtime) LogFile(i int) string {
+	return r.logFiles[i]
+}
+
+// ShardFor maps a partition key to a shard. Inputs without a partition key
+// are partitioned by topic.
+func (r *Runtime) ShardFor(input engine.Input) int {
+	key := input.Partition
+	if key == "" {
+		key, _, _ = strings.Cut(input.Line, "|")
+	}
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return int(h.Sum32() % uint32(len(r.shards)))
+}
+
+// Engine is the shard that the inputs of a partition go to
+func (r *Runtime) Engine(partition string) *engine.Engine {
+	return r.shards[r.ShardFor(engine.Input{Partition: partition})]
+}
+
+func (r *Runtime) nextGlobalSeq() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.globalSeq++
+	return r.globalSeq
+}
+
+// route stamps the input with its global arrival number
+func (r *Runtime) route(input engine.Input) (*engine.Engine, engine.Input) {
+	input.GlobalSeq = r.nextGlobalSeq()
+	return r.shards[r.ShardFor(input)], input
+}
+
+func (r *Runtime) Submit(input engine.Input) error {
+	e, input := r.route(input)
+	return e.Submit(input)
+}
+
+func (r *Runtime) TrySubmit(input engine.Input) error {
+	e, input := r.route(input)
+	return e.TrySubmit(input)
+}
+
+func (r *Runtime) SubmitWait(input engine.Input) (engine.Result, error) {
+	e, input := r.route(input)
+	return e.SubmitWait(input)
+}
+
+func (r *Runtime) TrySubmitWait(input engine.Input) (engine.Result, error) {
+	e, input := r.route(input)
+	return e.TrySubmitWait(input)
+}
+
+// MergedLog writes every shard's records as "shard|seq|kind|..." lines. The
+// shards are interleaved by the global arrival number of their inputs,
+// while each shard keeps its own processing order.
+func (r *Runtime) MergedLog(w io.Writer) error {
+	type cursor struct {
+		records []logreader.Record
+		pos     int
+	}
+	cursors := make([]*cursor, len(r.shards))
+	for i, logFile := range r.logFiles {
+		records, err := readRecords(logFile)
+		if err != nil {
+			return err
+		}
+		cursors[i] = &cursor{records: records}
+	}
+
+	for {
+		next := -1
+		nextGlobal := 0
+		for i, c := range cursors {
+			if c.pos >= len(c.records) {
+				continue
+			}
+			// A leading O record has no arrival number and sorts first
+			global := c.records[c.pos].Input.GlobalSeq
+			if next == -1 || global < nextGlobal {
+				next = i
+				nextGlobal = global
+			}
+		}
+		if next == -1 {
+			return nil
+		}
+
+		// Emit the input together with the outputs that follow it
+		c := cursors[next]
+		for {
+			if _, err := fmt.Fprintf(w, "%d|%s\n", next, c.records[c.pos].Raw); err != nil {
+				return err
+			}
+			c.pos++
+			if c.pos >= len(c.records) || c.records[c.pos].Kind == "I" {
+				break
+			}
+		}
+	}
+}
+
+// ReplayShard feeds shard i's logged steps, rejected lines included, into a
+// fresh in-memory engine and diffs what it logs against the shard's log,
+// every segment of it
+func (r *Runtime) ReplayShard(i int, context int) (*logdiff.Report, error) {
+	steps, err := logreader.ReadSteps(r.logFiles[i])
+	if err != nil {
+		return nil, err
+	}
+
+	sink := engine.NewMemorySink()
+	e := engine.NewEngineWithSink(sink)
+	e.SetReplayMode()
+	r.setupShard(e)
+	for _, step := range steps {
+		e.Apply(step.Input)
+	}
+	return logdiff.CompareRecords(r.logFiles[i], r.logFiles[i]+" (replay)", sink.Records(), context)
+}
+
+func readRecords(logFile string) ([]logreader.Record, error) {
+	reader, err := logreader.Open(logFile)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	var records []logreader.Record
+	for reader.Next() {
+		records = append(records, reader.Record())
+	}
+	return records, reader.Err()
+}
@@ -0,0 +1,115 @@
+package shard
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ivorytoast/replay78/apps"
+	"github.com/ivorytoast/replay78/engine"
+)
+
+func newTestRuntime(t *testing.T, n int) *Runtime {
+	t.Helper()
+	r := NewRuntime(filepath.Join(t.TempDir(), "78"), n, func(e *engine.Engine) {
+		e.RegisterApplication(apps.NewTicTacToeApp(e))
+	})
+	r.Run()
+	t.Cleanup(func() { r.Close() })
+	return r
+}
+
+func submitAll(t *testing.T, r *Runtime, inputs []engine.Input) {
+	t.Helper()
+	for _, input := range inputs {
+		if _, err := r.SubmitWait(input); err != nil {
+			t.Fatalf("SubmitWait(%s): %v", input.Line, err)
+		}
+	}
+}
+
+func TestRuntimeKeepsOneGamePerPartition(t *testing.T) {
+	tests := []struct {
+		name   string
+		shards int
+	}{
+		{"games share a shard", 1},
+		{"games spread over shards", 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newTestRuntime(t, tt.shards)
+			submitAll(t, r, []engine.Input{
+				{Line: "ttt|new|", Partition: "a"},
+				{Line: "ttt|new|", Partition: "b"},
+				{Line: "ttt|move|0 0 0 0", Partition: "a"},
+				{Line: "ttt|move|2 2 2 2", Partition: "b"},
+			})
+
+			for game, want := range map[string][2]int{"a": {0, 0}, "b": {2, 2}} {
+				e := r.Engine(game)
+				var player, other int
+				err := e.Inspect(func() {
+					board := e.PartitionState(game).GetBoard()
+					player = board[want[0]][want[1]].Player
+					other = board[2-want[0]][2-want[1]].Player
+				})
+				if err != nil {
+					t.Fatal(err)
+				}
+				if player != 1 || other != 0 {
+					t.Errorf("game %s: own cell held by %d, other game's cell by %d", game, player, other)
+				}
+			}
+		})
+	}
+}
+
+func TestMergedLogFollowsArrivalOrder(t *testing.T) {
+	r := newTestRuntime(t, 2)
+	games := []string{"a", "b", "c", "d", "e", "f"}
+	var inputs []engine.Input
+	for _, game := range games {
+		inputs = append(inputs, engine.Input{Line: "ttt|new|", Partition: game})
+	}
+	submitAll(t, r, inputs)
+
+	var merged bytes.Buffer
+	if err := r.MergedLog(&merged); err != nil {
+		t.Fatal(err)
+	}
+	var order []string
+	for _, line := range strings.Split(strings.TrimSpace(merged.String()), "\n") {
+		if _, part, ok := strings.Cut(line, "part="); ok && !strings.Contains(line, "|tick|") {
+			game, _, _ := strings.Cut(part, "&")
+			order = append(order, game)
+		}
+	}
+	if strings.Join(order, ",") != strings.Join(games, ",") {
+		t.Errorf("merged order = %v, want %v", order, games)
+	}
+}
+
+func TestReplayShard(t *testing.T) {
+	r := newTestRuntime(t, 2)
+	submitAll(t, r, []engine.Input{
+		{Line: "ttt|new|", Partition: "a"},
+		{Line: "garbage", Partition: "a"},
+		{Line: "ttt|new|", Partition: "b"},
+		{Line: "ttt|move|1 1 1 1", Partition: "a"},
+		{Line: "ttt|move|0 0 0 0", Partition: "b"},
+		{Line: "engine|generators|", Partition: "a"},
+		{Line: "engine|gen-stop|tick", Partition: "b"},
+	})
+
+	for i := 0; i < r.Shards(); i++ {
+		report, err := r.ReplayShard(i, 3)
+		if err != nil {
+			t.Fatalf("ReplayShard(%d): %v", i, err)
+		}
+		if !report.Equal {
+			t.Errorf("shard %d replay differs at seq %d", i, report.DivergenceSeq)
+		}
+	}
+}
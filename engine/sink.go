@@ -0,0 +1,284 @@
+package engine
+
+import (
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	networkSinkTimeout    = 2 * time.Second
+	networkSinkBuffer     = 4096 // Records held while the peer is slow or down
+	networkSinkMinBackoff = 100 * time.Millisecond
+	networkSinkMaxBackoff = 5 * time.Second
+)
+
+// LogSink receives every record the engine writes, in seq order, one record
+// per call without the trailing newline. Write is called on the engine loop.
+type LogSink interface {
+	Write(record string) error
+	Close() error
+}
+
+// rotatingSink is implemented by sinks that can start a new segment, see
+// the engine|rotate| command
+type rotatingSink interface {
+	Rotate() (segment int, err error)
+}
+
+// FileSink writes the log to a file, continuing in numbered segments after
+// each rotation
+type FileSink struct {
+	path    string
+	segment int
+	file    *os.File
+}
+
+// NewFileSink creates (or truncates) the log file at path
+func NewFileSink(path string) (*FileSink, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	return &FileSink{path: path, segment: 1, file: file}, nil
+}
+
+func (s *FileSink) Write(record string) error {
+	_, err := s.file.WriteString(record + "\n")
+	return err
+}
+
+func (s *FileSink) Close() error {
+	return s.file.Close()
+}
+
+// Rotate closes the current segment and continues in the next one. Seqs
+// carry on unbroken across segments.
+func (s *FileSink) Rotate() (int, error) {
+	next, err := os.Create(segmentPath(s.path, s.segment+1))
+	if err != nil {
+		return s.segment, err
+	}
+	s.file.Close()
+	s.file = next
+	s.segment++
+	return s.segment, nil
+}
+
+// MemorySink keeps the log in memory, e.g. for tests
+type MemorySink struct {
+	mu        sync.Mutex
+	records   []string
+	rotations int
+}
+
+func NewMemorySink() *MemorySink {
+	return &MemorySink{}
+}
+
+func (s *MemorySink) Write(record string) error {
+	s.mu.Lock()
+	s.records = append(s.records, record)
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *MemorySink) Close() error {
+	return nil
+}
+
+// Rotate only counts segments, so that replaying engine|rotate| in memory
+// reports the same segment as the run that logged it. Records keeps the
+// whole log, as logreader reads every segment of a file log back.
+func (s *MemorySink) Rotate() (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rotations++
+	return s.rotations + 1, nil
+}
+
+// Records returns a copy of everything written so far
+func (s *MemorySink) Records() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.records...)
+}
+
+// Bytes returns the log exactly as a FileSink would have written it
+func (s *MemorySink) Bytes() []byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.records) == 0 {
+		return nil
+	}
+	return []byte(strings.Join(s.records, "\n") + "\n")
+}
+
+// MultiSink tees the log to several sinks, e.g. a file plus a replication
+// stream. Every sink sees every record even if an earlier one fails.
+type MultiSink struct {
+	sinks []LogSink
+}
+
+func NewMultiSink(sinks ...LogSink) *MultiSink {
+	return &MultiSink{sinks: sinks}
+}
+
+func (s *MultiSink) Write(record string) error {
+	var firstErr error
+	for _, sink := range s.sinks {
+		if err := sink.Write(record); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+func (s *MultiSink) Close() error {
+	var firstErr error
+	for _, sink := range s.sinks {
+		if err := sink.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// Rotate rotates every member that supports it and reports the segment of
+// the first one
+func (s *MultiSink) Rotate() (int, error) {
+	segment := 0
+	for _, sink := range s.sinks {
+		r, ok := sink.(rotatingSink)
+		if !ok {
+			continue
+		}
+		n, err := r.Rotate()
+		if err != nil {
+			return n, err
+		}
+		if segment == 0 {
+			segment = n
+		}
+	}
+	if segment == 0 {
+		return 0, errNoRotation
+	}
+	return segment, nil
+}
+
+// NetworkSink sends each record as a line to a TCP or Unix socket. Records
+// are handed to a background sender, so a slow or unreachable peer never
+// stalls the engine: while disconnected the sender redials with backoff and
+// records wait in a bounded buffer, and once that is full new records are
+// dropped and counted.
+type NetworkSink struct {
+	network string
+	addr    string
+
+	records chan string
+	done    chan struct{}
+	stopped chan struct{}
+	once    sync.Once
+	dropped atomic.Int64
+}
+
+func NewNetworkSink(network, addr string) *NetworkSink {
+	s := &NetworkSink{
+		network: network,
+		addr:    addr,
+		records: make(chan string, networkSinkBuffer),
+		done:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	go s.send()
+	return s
+}
+
+func (s *NetworkSink) Write(record string) error {
+	select {
+	case <-s.done:
+		return net.ErrClosed
+	default:
+	}
+	select {
+	case s.records <- record:
+	default:
+		s.dropped.Add(1)
+	}
+	return nil
+}
+
+// Dropped is how many records never reached the peer because the buffer was
+// full or the sink closed before they could be sent
+func (s *NetworkSink) Dropped() int64 {
+	return s.dropped.Load()
+}
+
+// Close stops the sender once it has flushed what it can to the current
+// connection, without redialing
+func (s *NetworkSink) Close() error {
+	s.once.Do(func() { close(s.done) })
+	<-s.stopped
+	return nil
+}
+
+func (s *NetworkSink) send() {
+	var conn net.Conn
+	defer func() {
+		if conn != nil {
+			conn.Close()
+		}
+		s.dropped.Add(int64(len(s.records)))
+		close(s.stopped)
+	}()
+
+	backoff := networkSinkMinBackoff
+	for {
+		var record string
+		select {
+		case record = <-s.records:
+		case <-s.done:
+			for conn != nil && len(s.records) > 0 {
+				if !s.write(conn, <-s.records) {
+					s.dropped.Add(1)
+					return
+				}
+			}
+			return
+		}
+
+		// Keep the record until it is written, redialing as often as it takes
+		for {
+			if conn == nil {
+				dialed, err := net.DialTimeout(s.network, s.addr, networkSinkTimeout)
+				if err != nil {
+					select {
+					case <-time.After(backoff):
+					case <-s.done:
+						s.dropped.Add(1)
+						return
+					}
+					backoff = min(2*backoff, networkSinkMaxBackoff)
+					continue
+				}
+				conn = dialed
+				backoff = networkSinkMinBackoff
+			}
+			if s.write(conn, record) {
+				break
+			}
+			conn.Close()
+			conn = nil
+		}
+	}
+}
+
+func (s *NetworkSink) write(conn net.Conn, record string) bool {
+	conn.SetWriteDeadline(time.Now().Add(networkSinkTimeout))
+	_, err := conn.Write([]byte(record + "\n"))
+	return err == nil
+}
@@ -0,0 +1,82 @@
+package engine
+
+import (
+	"bufio"
+	"net"
+	"testing"
+	"time"
+)
+
+// readLine reads one record from conn, failing the test if none arrives
+func readLine(t *testing.T, reader *bufio.Reader, conn net.Conn) string {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		t.Fatalf("reading record: %v", err)
+	}
+	return line[:len(line)-1]
+}
+
+func TestNetworkSinkSurvivesPeerOutage(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := listener.Addr().String()
+	s := NewNetworkSink("tcp", addr)
+	defer s.Close()
+
+	s.Write("1|O|first")
+	conn, err := listener.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := readLine(t, bufio.NewReader(conn), conn); got != "1|O|first" {
+		t.Fatalf("got %q, want the first record", got)
+	}
+	conn.Close()
+	listener.Close()
+
+	// With the peer gone, writes must neither block nor fail
+	start := time.Now()
+	for i := 0; i < 2*networkSinkBuffer; i++ {
+		if err := s.Write("2|O|while down"); err != nil {
+			t.Fatalf("Write while the peer is down: %v", err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("writes while the peer was down took %v", elapsed)
+	}
+	if s.Dropped() == 0 {
+		t.Error("Dropped = 0, want the records past the buffer counted")
+	}
+
+	listener, err = net.Listen("tcp", addr)
+	if err != nil {
+		t.Skipf("cannot listen on %s again: %v", addr, err)
+	}
+	defer listener.Close()
+	conn, err = listener.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// Once the buffered records start to arrive there is room for another
+	reader := bufio.NewReader(conn)
+	readLine(t, reader, conn)
+	s.Write("3|O|after reconnect")
+	for readLine(t, reader, conn) != "3|O|after reconnect" {
+	}
+}
+
+func TestNetworkSinkWriteAfterClose(t *testing.T) {
+	s := NewNetworkSink("tcp", "127.0.0.1:1")
+	if err := s.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if err := s.Write("1|O|late"); err == nil {
+		t.Error("Write after Close succeeded")
+	}
+}
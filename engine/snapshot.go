@@ -0,0 +1,139 @@
+package engine
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ivorytoast/replay78/states"
+)
+
+// Snapshot is the engine's state as of the end of record Seq
+type Snapshot struct {
+	Seq        int                               `json:"seq"`
+	TicTacToe  *states.TicTacToeState            `json:"tictactoe"`
+	Partitions map[string]*states.TicTacToeState `json:"partitions,omitempty"`
+	RecentKeys []KeyResult                       `json:"recentKeys"`
+
+	// What engine commands and ticks changed, so a restored engine carries
+	// on the way the original would have
+	Clock          time.Time         `json:"clock,omitzero"`
+	DispatchPaused bool              `json:"dispatchPaused,omitempty"`
+	Generators     []GeneratorStatus `json:"generators,omitempty"`
+	InputCount     int               `json:"inputCount,omitempty"` // Inputs so far, which periodic snapshots count
+}
+
+// Snapshot captures the current state. Only call it from inside the
+// engine loop (e.g. an application's OnEvent) or while the engine is idle.
+func (e *Engine) Snapshot() *Snapshot {
+	snapshot := &Snapshot{
+		Seq:        e.seq,
+		TicTacToe:  e.TicTacToeState.Clone(),
+		RecentKeys: e.dedup.entries(),
+		InputCount: e.inputCount,
+	}
+	for partition, state := range e.partitions {
+		if snapshot.Partitions == nil {
+			snapshot.Partitions = make(map[string]*states.TicTacToeState)
+		}
+		snapshot.Partitions[partition] = state.Clone()
+	}
+	snapshot.Clock = e.clock
+	snapshot.DispatchPaused = e.dispatchPaused
+	for _, entry := range e.generators {
+		source := entry.gen.Source()
+		snapshot.Generators = append(snapshot.Generators, GeneratorStatus{
+			Name:    source.ID,
+			Kind:    source.Kind,
+			State:   entry.state,
+			LastSeq: entry.lastSeq,
+		})
+	}
+	return snapshot
+}
+
+// Restore loads a snapshot into an engine that has not been started
+func (e *Engine) Restore(s *Snapshot) {
+	e.seq = s.Seq
+	*e.TicTacToeState = *s.TicTacToe.Clone()
+	e.partitions = make(map[string]*states.TicTacToeState)
+	for partition, state := range s.Partitions {
+		e.partitions[partition] = state.Clone()
+	}
+	e.dedup.load(s.RecentKeys)
+	e.inputCount = s.InputCount
+	e.clock = s.Clock
+	e.dispatchPaused = s.DispatchPaused
+	if !e.replayMode {
+		e.queue.setPaused(e.dispatchPaused)
+	}
+	for _, status := range s.Generators {
+		if entry := e.findGenerator(status.Kind, status.Name); entry != nil {
+			entry.state = status.State
+			entry.lastSeq = status.LastSeq
+		}
+	}
+}
+
+// SetSnapshotInterval writes a snapshot file next to the log after every
+// n inputs. 0 disables periodic snapshots.
+func (e *Engine) SetSnapshotInterval(n int) {
+	e.snapshotInterval = n
+}
+
+// writeSnapshot does nothing for an engine without a log file, such as an
+// in-memory replay, which reports the snapshot as the live run did
+func (e *Engine) writeSnapshot() error {
+	if e.logFile == "" {
+		return nil
+	}
+	snapshot := e.Snapshot()
+	data, err := json.MarshalIndent(snapshot, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(snapshotPath(e.logFile, snapshot.Seq), data, 0644)
+}
+
+func snapshotPath(logFile string, seq int) string {
+	return fmt.Sprintf("%s.snap-%d.json", strings.TrimSuffix(logFile, ".log"), seq)
+}
+
+// ListSnapshots returns the snapshot files written for a log, oldest first
+func ListSnapshots(logFile string) ([]string, error) {
+	pattern := strings.TrimSuffix(logFile, ".log") + ".snap-*.json"
+	files, err := filepath.Glob(pattern)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return snapshotSeq(files[i]) < snapshotSeq(files[j])
+	})
+	return files, nil
+}
+
+func snapshotSeq(path string) int {
+	var seq int
+	name := path[strings.LastIndex(path, ".snap-")+len(".snap-"):]
+	fmt.Sscanf(name, "%d.json", &seq)
+	return seq
+}
+
+func LoadSnapshot(path string) (*Snapshot, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var snapshot Snapshot
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return nil, err
+	}
+	if snapshot.TicTacToe == nil {
+		return nil, fmt.Errorf("snapshot %s has no tictactoe state", path)
+	}
+	return &snapshot, nil
+}
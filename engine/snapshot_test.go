@@ -0,0 +1,83 @@
+package engine
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestInMemoryReplayOfAdminCommands(t *testing.T) {
+	tests := []struct {
+		name   string
+		inputs []string
+	}{
+		{"snapshot", []string{"ttt|new|", "engine|snapshot|", "ttt|show|"}},
+		{"rotate", []string{"ttt|new|", "engine|rotate|", "ttt|show|", "engine|rotate|", "ttt|show|"}},
+		{"both", []string{"engine|snapshot|", "engine|rotate|", "engine|snapshot|"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logFile := filepath.Join(t.TempDir(), "78.log")
+			live := NewEngineWithLogFile(logFile)
+			live.SetReplayMode()
+			for _, line := range tt.inputs {
+				live.Apply(Input{Line: line})
+			}
+			live.Close()
+			var want []byte
+			for _, segment := range LogSegments(logFile) {
+				data, err := os.ReadFile(segment)
+				if err != nil {
+					t.Fatal(err)
+				}
+				want = append(want, data...)
+			}
+
+			// A replay without a log file must not write snapshots anywhere
+			t.Chdir(t.TempDir())
+			sink := NewMemorySink()
+			replay := NewEngineWithSink(sink)
+			replay.SetReplayMode()
+			for _, line := range tt.inputs {
+				replay.Apply(Input{Line: line})
+			}
+			if got := sink.Bytes(); string(got) != string(want) {
+				t.Errorf("replay logged\n%s\nlive run logged\n%s", got, want)
+			}
+			if files, _ := filepath.Glob("*"); len(files) > 0 {
+				t.Errorf("replay wrote %v", files)
+			}
+		})
+	}
+}
+
+func TestRestoreKeepsSnapshotCadence(t *testing.T) {
+	original := NewEngineWithLogFile(filepath.Join(t.TempDir(), "78.log"))
+	original.SetReplayMode()
+	original.SetSnapshotInterval(3)
+	for range 4 {
+		original.Apply(Input{Line: "tick|tock|1700000000000000000"})
+	}
+	snapshot := original.Snapshot()
+	original.Close()
+
+	logFile := filepath.Join(t.TempDir(), "78.log")
+	restored := NewEngineWithLogFile(logFile)
+	restored.SetReplayMode()
+	restored.SetSnapshotInterval(3)
+	restored.Restore(snapshot)
+	var seqs []int
+	for range 2 {
+		seqs = append(seqs, restored.Apply(Input{Line: "tick|tock|1700000000000000000"}).Seq)
+	}
+	restored.Close()
+
+	// The sixth input overall is the second after the restore
+	files, err := ListSnapshots(logFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 || snapshotSeq(files[0]) != seqs[1] {
+		t.Errorf("snapshots %v after restoring at input 4, want one at seq %d", files, seqs[1])
+	}
+}
@@ -0,0 +1,167 @@
+package engine
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+const defaultTailPollInterval = 200 * time.Millisecond
+
+// FileTailGenerator feeds each line appended to a file, or written to a
+// named pipe, into the engine. Every input records the byte offset just past
+// its line, so on restart the generator resumes after the last line the
+// previous run logged instead of re-reading the whole file.
+type FileTailGenerator struct {
+	GeneratorControl
+	Name         string
+	Path         string
+	PollInterval time.Duration // How often to check a regular file for new lines
+
+	// FindOffset returns the offset of the last input from source in a run's
+	// log, every segment of it, and whether there was one. The engine cannot
+	// read its logs back itself, so callers pass logreader.LastOffset. Without
+	// it the generator always starts at the beginning of the file.
+	FindOffset func(logFile string, source Source) (int64, bool, error)
+}
+
+func NewFileTailGenerator(name, path string) *FileTailGenerator {
+	return &FileTailGenerator{
+		Name:         name,
+		Path:         path,
+		PollInterval: defaultTailPollInterval,
+	}
+}
+
+func (g *FileTailGenerator) Source() Source {
+	return Source{Kind: "tail", ID: g.Name}
+}
+
+// Start resumes from the offset of the last line logged, by this run if the
+// generator was stopped and started again, otherwise by the latest run that
+// had it
+func (g *FileTailGenerator) Start(e *Engine) {
+	offset := g.resumeOffset(e)
+	run := g.current()
+	go func() {
+		for {
+			var err error
+			offset, err = g.tail(e, run, offset)
+			if errors.Is(err, ErrGeneratorStopped) {
+				return
+			} else if err != nil {
+				g.fail(err)
+				e.logf(LogWarn, "File tail generator %s: %v", g.Name, err)
+			}
+			if !g.sleep(run, g.PollInterval) {
+				return
+			}
+		}
+	}()
+	e.logf(LogInfo, "Started file tail generator on %s (offset: %d)", g.Path, offset)
+}
+
+// tail reads lines from offset until the file goes away or, for a named
+// pipe, the writer closes it. It returns the offset to carry on from, and
+// ErrGeneratorStopped once run should exit.
+func (g *FileTailGenerator) tail(e *Engine, run int, offset int64) (int64, error) {
+	file, err := os.Open(g.Path)
+	if err != nil {
+		return offset, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return offset, err
+	}
+	pipe := info.Mode()&os.ModeNamedPipe != 0
+	if !pipe {
+		// A file shorter than the offset was truncated or replaced
+		if info.Size() < offset {
+			e.logf(LogWarn, "File tail generator %s: %s shrank, starting over", g.Name, g.Path)
+			offset = 0
+		}
+		if _, err := file.Seek(offset, io.SeekStart); err != nil {
+			return offset, err
+		}
+	}
+
+	reader := bufio.NewReader(file)
+	partial := ""
+	for {
+		chunk, err := reader.ReadString('\n')
+		partial += chunk
+		if errors.Is(err, io.EOF) {
+			if pipe {
+				// Writer went away, reopen and wait for the next one
+				offset += int64(len(partial))
+				return offset, g.submit(e, run, partial, offset)
+			}
+			if info, statErr := os.Stat(g.Path); statErr != nil || info.Size() < offset {
+				return offset, statErr
+			}
+			if !g.sleep(run, g.PollInterval) {
+				return offset, ErrGeneratorStopped
+			}
+			continue
+		} else if err != nil {
+			return offset, err
+		}
+
+		if err := g.submit(e, run, partial, offset+int64(len(partial))); err != nil {
+			return offset, err
+		}
+		offset += int64(len(partial))
+		partial = ""
+	}
+}
+
+// submit waits out a pause before sending the line, so a paused generator
+// leaves the rest of the file unread
+func (g *FileTailGenerator) submit(e *Engine, run int, line string, offset int64) error {
+	line = strings.TrimSpace(line)
+	if line == "" {
+		return nil
+	}
+	if !g.await(run) {
+		return ErrGeneratorStopped
+	}
+	input := Input{
+		Line:   line,
+		Source: g.Source(),
+		Offset: offset,
+	}
+	if err := e.Submit(input); err != nil {
+		g.fail(err)
+		e.logf(LogWarn, "File tail generator input rejected: %v", err)
+	}
+	return nil
+}
+
+// resumeOffset asks FindOffset for the offset recorded by this generator's
+// last input, looking through this run's log and then back through earlier
+// runs, so a run without the generator in between does not start it over
+func (g *FileTailGenerator) resumeOffset(e *Engine) int64 {
+	if g.FindOffset == nil {
+		return 0
+	}
+	var logs []string
+	if e.LogFile() != "" {
+		logs = append(logs, e.LogFile())
+	}
+	for _, logFile := range append(logs, e.EarlierLogFiles()...) {
+		offset, found, err := g.FindOffset(logFile, g.Source())
+		if err != nil {
+			e.logf(LogWarn, "File tail generator %s: reading %s: %v", g.Name, logFile, err)
+			continue
+		}
+		if found {
+			return offset
+		}
+	}
+	return 0
+}
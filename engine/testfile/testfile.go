@@ -0,0 +1,278 @@
+// Package testfile reads the regression test files under fuzz_tests. Each
+// non-blank line is an input, except for # comments and expectations, which
+// state what must hold once the inputs above them have been applied. Comments
+// before the first input may tag the test so runs can select it:
+//
+//	# tags: combat, endgame
+//	ttt|move|0 0 0 0
+//	expect board X........
+//	expect bank 1 = 0
+//	ttt|endturn|
+//	expect turn 2
+//	expect phase assignment
+//	expect output ~ /Turn ended/
+//
+// Expectations check the game state, or the outputs of the input just
+// before them:
+//
+//	expect board <cells>        the board as the output shows it, e.g. XO.X2.....
+//	expect bank <player> = <n>  a player's power bank
+//	expect turn <player>        whose turn it is
+//	expect phase <phase>        assignment or movement
+//	expect done [true|false]    whether the game is over
+//	expect output ~ /<regex>/   some output matches
+//	expect output !~ /<regex>/  no output matches
+//	expect output = <text>      some output is exactly text
+package testfile
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/ivorytoast/replay78/engine"
+	"github.com/ivorytoast/replay78/states"
+)
+
+const (
+	expectPrefix = "expect "
+	tagsPrefix   = "tags:"
+)
+
+// File is a parsed test file
+type File struct {
+	Path         string
+	Tags         []string
+	Inputs       []engine.Input
+	Expectations []Expectation
+}
+
+// Expectation is one expect line
+type Expectation struct {
+	Line  int    // Line number in the file
+	Text  string // The line as written
+	After int    // Inputs applied before it is checked
+
+	check func(state *states.TicTacToeState, outputs []string) error
+}
+
+// Parse reads a test file. Inputs carry a file source naming path.
+func Parse(path string) (*File, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	f := &File{Path: path}
+	source := engine.Source{Kind: "file", ID: path}
+	scanner := bufio.NewScanner(file)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if comment, ok := strings.CutPrefix(line, "#"); ok {
+			if tags, ok := strings.CutPrefix(strings.TrimSpace(comment), tagsPrefix); ok && len(f.Inputs) == 0 {
+				f.Tags = append(f.Tags, parseTags(tags)...)
+			}
+			continue
+		}
+		if line == "" {
+			continue
+		}
+		if !strings.HasPrefix(line, expectPrefix) {
+			f.Inputs = append(f.Inputs, engine.Input{Line: line, Source: source})
+			continue
+		}
+		check, err := parseExpectation(strings.TrimSpace(strings.TrimPrefix(line, expectPrefix)))
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", path, n, err)
+		}
+		f.Expectations = append(f.Expectations, Expectation{Line: n, Text: line, After: len(f.Inputs), check: check})
+	}
+	return f, scanner.Err()
+}
+
+// Check applies the inputs on a fresh in-memory engine, which setup
+// registers the applications on, and returns one error per expectation
+// that does not hold
+func (f *File) Check(setup func(*engine.Engine)) []error {
+	e := engine.NewEngineWithSink(engine.NewMemorySink())
+	e.SetReplayMode()
+	setup(e)
+
+	var failures []error
+	var outputs []string
+	applied := 0
+	for _, expectation := range f.Expectations {
+		for ; applied < expectation.After; applied++ {
+			outputs = e.Apply(f.Inputs[applied]).Outputs
+		}
+		if err := expectation.check(e.TTT(), outputs); err != nil {
+			failures = append(failures, fmt.Errorf("%s:%d: %s: %v", f.Path, expectation.Line, expectation.Text, err))
+		}
+	}
+	return failures
+}
+
+// HasTag reports whether the test is tagged tag, ignoring case
+func (f *File) HasTag(tag string) bool {
+	for _, t := range f.Tags {
+		if strings.EqualFold(t, tag) {
+			return true
+		}
+	}
+	return false
+}
+
+func parseExpectation(spec string) (func(*states.TicTacToeState, []string) error, error) {
+	subject, rest, _ := strings.Cut(spec, " ")
+	rest = strings.TrimSpace(rest)
+
+	switch subject {
+	case "board":
+		want := strings.Join(strings.Fields(rest), "")
+		if want == "" {
+			return nil, fmt.Errorf("expect board needs the cells, e.g. X.O......")
+		}
+		return func(state *states.TicTacToeState, _ []string) error {
+			if got := flatten(state); got != want {
+				return fmt.Errorf("board is %s", got)
+			}
+			return nil
+		}, nil
+
+	case "bank":
+		fields := strings.Fields(strings.Replace(rest, "=", " ", 1))
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("expect bank needs a player and an amount, e.g. bank 1 = 2")
+		}
+		player, err := parsePlayer(fields[0])
+		if err != nil {
+			return nil, err
+		}
+		want, err := strconv.Atoi(fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid amount %q", fields[1])
+		}
+		return func(state *states.TicTacToeState, _ []string) error {
+			if got := state.GetPowerBank(player); got != want {
+				return fmt.Errorf("bank is %d", got)
+			}
+			return nil
+		}, nil
+
+	case "turn":
+		player, err := parsePlayer(rest)
+		if err != nil {
+			return nil, err
+		}
+		return func(state *states.TicTacToeState, _ []string) error {
+			if got := state.GetCurrentPlayer(); got != player {
+				return fmt.Errorf("it is player %d's turn", got)
+			}
+			return nil
+		}, nil
+
+	case "phase":
+		var want states.TurnPhase
+		switch strings.ToLower(rest) {
+		case "assignment":
+			want = states.PhaseAssignment
+		case "movement":
+			want = states.PhaseMovement
+		default:
+			return nil, fmt.Errorf("unknown phase %q, use assignment or movement", rest)
+		}
+		return func(state *states.TicTacToeState, _ []string) error {
+			if got := state.GetCurrentPhase(); got != want {
+				return fmt.Errorf("phase is %s", got)
+			}
+			return nil
+		}, nil
+
+	case "done":
+		want := true
+		if rest != "" {
+			var err error
+			if want, err = strconv.ParseBool(rest); err != nil {
+				return nil, fmt.Errorf("expect done takes true or false, not %q", rest)
+			}
+		}
+		return func(state *states.TicTacToeState, _ []string) error {
+			if got := state.IsDone(); got != want {
+				return fmt.Errorf("game over is %t", got)
+			}
+			return nil
+		}, nil
+
+	case "output":
+		return parseOutputExpectation(rest)
+	}
+	return nil, fmt.Errorf("unknown expectation %q", subject)
+}
+
+func parseOutputExpectation(rest string) (func(*states.TicTacToeState, []string) error, error) {
+	op, operand, _ := strings.Cut(rest, " ")
+	operand = strings.TrimSpace(operand)
+
+	var match func(string) bool
+	switch op {
+	case "=":
+		match = func(output string) bool { return output == operand }
+	case "~", "!~":
+		pattern := operand
+		if len(pattern) >= 2 && strings.HasPrefix(pattern, "/") && strings.HasSuffix(pattern, "/") {
+			pattern = pattern[1 : len(pattern)-1]
+		}
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern: %v", err)
+		}
+		match = re.MatchString
+	default:
+		return nil, fmt.Errorf("expect output takes ~ /regex/, !~ /regex/ or = text")
+	}
+
+	return func(_ *states.TicTacToeState, outputs []string) error {
+		found := false
+		for _, output := range outputs {
+			if match(output) {
+				found = true
+				break
+			}
+		}
+		if found == (op == "!~") {
+			return fmt.Errorf("outputs were %q", outputs)
+		}
+		return nil
+	}, nil
+}
+
+func parseTags(list string) []string {
+	var tags []string
+	for _, tag := range strings.FieldsFunc(list, func(r rune) bool { return r == ',' || r == ' ' }) {
+		tags = append(tags, strings.ToLower(tag))
+	}
+	return tags
+}
+
+func parsePlayer(s string) (int, error) {
+	player, err := strconv.Atoi(s)
+	if err != nil || player < 1 || player > 2 {
+		return 0, fmt.Errorf("invalid player %q, use 1 or 2", s)
+	}
+	return player, nil
+}
+
+// flatten renders the board the way the game prints it after each input
+func flatten(state *states.TicTacToeState) string {
+	var b strings.Builder
+	for _, row := range state.GetBoard() {
+		for _, cell := range row {
+			b.WriteString(cell.String())
+		}
+	}
+	return b.String()
+}
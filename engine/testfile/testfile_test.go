@@ -0,0 +1,143 @@
+package testfile
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ivorytoast/replay78/apps"
+	"github.com/ivorytoast/replay78/engine"
+)
+
+func writeTest(t *testing.T, lines ...string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "test.txt")
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name    string
+		lines   []string
+		inputs  int
+		tags    []string
+		after   []int // After of each expectation
+		wantErr string
+	}{
+		{"inputs only", []string{"ttt|new|", "", "  ttt|show|  "}, 2, nil, nil, ""},
+		{"tags before inputs", []string{"# tags: Combat, endgame", "ttt|new|", "# tags: late"}, 1, []string{"combat", "endgame"}, nil, ""},
+		{"expectations count inputs above", []string{"expect turn 1", "ttt|new|", "expect turn 1", "expect phase assignment", "ttt|show|", "expect done false"}, 2, nil, []int{0, 1, 1, 2}, ""},
+		{"every kind", []string{
+			"ttt|new|",
+			"expect board X . . . . . . . .",
+			"expect bank 2 = 3",
+			"expect bank 1=0",
+			"expect done",
+			"expect output ~ /Turn/",
+			"expect output !~ Win",
+			"expect output = Game Started",
+		}, 1, nil, []int{1, 1, 1, 1, 1, 1, 1}, ""},
+		{"unknown subject", []string{"ttt|new|", "expect score 3"}, 0, nil, nil, ":2: unknown expectation"},
+		{"bad player", []string{"expect turn 3"}, 0, nil, nil, "invalid player"},
+		{"bad bank", []string{"expect bank 1"}, 0, nil, nil, "needs a player and an amount"},
+		{"bad phase", []string{"expect phase combat"}, 0, nil, nil, "unknown phase"},
+		{"bad done", []string{"expect done maybe"}, 0, nil, nil, "true or false"},
+		{"bad regex", []string{"expect output ~ /(/"}, 0, nil, nil, "invalid pattern"},
+		{"bad output op", []string{"expect output has Turn"}, 0, nil, nil, "expect output takes"},
+		{"empty board", []string{"expect board"}, 0, nil, nil, "needs the cells"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := Parse(writeTest(t, tt.lines...))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Parse error %v, want one containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(f.Inputs) != tt.inputs {
+				t.Errorf("%d inputs, want %d", len(f.Inputs), tt.inputs)
+			}
+			if strings.Join(f.Tags, ",") != strings.Join(tt.tags, ",") {
+				t.Errorf("tags %v, want %v", f.Tags, tt.tags)
+			}
+			var after []int
+			for _, expectation := range f.Expectations {
+				after = append(after, expectation.After)
+			}
+			if len(after) != len(tt.after) {
+				t.Fatalf("expectations after %v inputs, want %v", after, tt.after)
+			}
+			for i := range after {
+				if after[i] != tt.after[i] {
+					t.Errorf("expectations after %v inputs, want %v", after, tt.after)
+					break
+				}
+			}
+		})
+	}
+}
+
+func TestCheck(t *testing.T) {
+	tests := []struct {
+		name     string
+		lines    []string
+		failures []int // Lines of the expectations that fail
+	}{
+		{"all hold", []string{
+			"ttt|new|",
+			"ttt|move|0 0 0 0",
+			"expect board X........",
+			"expect bank 1 = 0",
+			"ttt|endturn|",
+			"expect turn 2",
+			"expect phase assignment",
+			"expect done false",
+		}, nil},
+		{"state mismatches", []string{
+			"ttt|new|",
+			"expect turn 2",
+			"expect board X........",
+			"expect done",
+		}, []int{2, 3, 4}},
+		{"outputs of the last input only", []string{
+			"ttt|new|",
+			"ttt|move|x",
+			"expect output = invalid user action",
+			"expect output !~ /invalid/",
+		}, []int{4}},
+	}
+	setup := func(e *engine.Engine) { e.RegisterApplication(apps.NewTicTacToeApp(e)) }
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := Parse(writeTest(t, tt.lines...))
+			if err != nil {
+				t.Fatal(err)
+			}
+			failed := make(map[int]bool)
+			for _, err := range f.Check(setup) {
+				for _, expectation := range f.Expectations {
+					if strings.Contains(err.Error(), expectation.Text) {
+						failed[expectation.Line] = true
+					}
+				}
+			}
+			for _, line := range tt.failures {
+				if !failed[line] {
+					t.Errorf("expectation on line %d held, want it to fail", line)
+				}
+				delete(failed, line)
+			}
+			for line := range failed {
+				t.Errorf("expectation on line %d failed", line)
+			}
+		})
+	}
+}
@@ -0,0 +1,165 @@
+package timetravel
+
+import (
+	"slices"
+	"strings"
+
+	"github.com/ivorytoast/replay78/engine"
+	"github.com/ivorytoast/replay78/engine/logreader"
+	"github.com/ivorytoast/replay78/states"
+)
+
+// Divergence is the first input whose replay did not match the original
+type Divergence struct {
+	Index    int // 0-based position of the input among the original's steps
+	Input    engine.Input
+	Seq      int // Seq the original logged the input at
+	Expected []string
+	Actual   []string
+
+	// Partition is the game the states below belong to: the first one
+	// whose state differs from the original's snapshot, else the input's
+	// own. "" is the engine's default game.
+	Partition string
+
+	// ExpectedState is the original's state after the input, known only
+	// where the original wrote a snapshot. Before and After are the
+	// replay's state around the input.
+	ExpectedState *states.TicTacToeState
+	Before        *states.TicTacToeState
+	After         *states.TicTacToeState
+}
+
+// OutputsDiffer tells an output divergence from one only the state shows
+func (d *Divergence) OutputsDiffer() bool {
+	if len(d.Expected) != len(d.Actual) {
+		return true
+	}
+	for i := range d.Expected {
+		if d.Expected[i] != d.Actual[i] {
+			return true
+		}
+	}
+	return false
+}
+
+// StateDiff compares the expected state with the replay's when the
+// original has a snapshot at this point, otherwise it shows what the input
+// changed in the replay
+func (d *Divergence) StateDiff() []string {
+	if d.ExpectedState != nil {
+		return d.ExpectedState.Diff(d.After)
+	}
+	return d.Before.Diff(d.After)
+}
+
+// FirstDivergence replays the inputs of the run logged at logFile on a
+// fresh engine and returns the first one whose records differ from the
+// original's, or after which any game's state differs from a snapshot the
+// original took. It returns nil if the whole replay matches. The replay is
+// a single pass, so the first divergence is exact without narrowing it down
+// by halves.
+func FirstDivergence(logFile string, setup func(*engine.Engine)) (*Divergence, error) {
+	steps, err := logreader.ReadSteps(logFile)
+	if err != nil {
+		return nil, err
+	}
+	snapshots, err := loadSnapshots(logFile)
+	if err != nil {
+		return nil, err
+	}
+
+	sink := engine.NewMemorySink()
+	e := engine.NewEngineWithSink(sink)
+	e.SetReplayMode()
+	setup(e)
+
+	written := 0
+	for i, step := range steps {
+		before := e.Snapshot()
+		e.Apply(step.Input)
+		after := e.Snapshot()
+
+		records := sink.Records()
+		actual := make([]string, 0, len(records)-written)
+		for _, record := range records[written:] {
+			_, body, _ := strings.Cut(record, "|")
+			actual = append(actual, body)
+		}
+		written = len(records)
+
+		game := step.Input.Partition
+		expected, ok := snapshots[e.Seq()]
+		stateDiffers := false
+		if ok {
+			if diverged, found := divergedGame(expected, after); found {
+				game, stateDiffers = diverged, true
+			}
+		}
+		d := &Divergence{
+			Index:     i,
+			Input:     step.Input,
+			Seq:       step.Seq,
+			Expected:  step.Records,
+			Actual:    actual,
+			Partition: game,
+			Before:    gameState(before, game),
+			After:     gameState(after, game),
+		}
+		if ok {
+			d.ExpectedState = gameState(expected, game)
+		}
+		if d.OutputsDiffer() || stateDiffers {
+			return d, nil
+		}
+	}
+	return nil, nil
+}
+
+// divergedGame returns the first game, the default one and then the
+// partitions by name, whose state differs between two snapshots
+func divergedGame(expected, actual *engine.Snapshot) (string, bool) {
+	games := []string{""}
+	for partition := range expected.Partitions {
+		games = append(games, partition)
+	}
+	for partition := range actual.Partitions {
+		if _, ok := expected.Partitions[partition]; !ok {
+			games = append(games, partition)
+		}
+	}
+	slices.Sort(games[1:])
+	for _, game := range games {
+		if gameState(expected, game).Hash() != gameState(actual, game).Hash() {
+			return game, true
+		}
+	}
+	return "", false
+}
+
+// gameState is a game's state in a snapshot. A partition the snapshot does
+// not have is still a fresh game, as the engine would create it.
+func gameState(snapshot *engine.Snapshot, game string) *states.TicTacToeState {
+	if game == "" {
+		return snapshot.TicTacToe
+	}
+	if state, ok := snapshot.Partitions[game]; ok {
+		return state
+	}
+	return states.NewTicTacToeState()
+}
+
+// loadSnapshots reads every snapshot of logFile, by seq
+func loadSnapshots(logFile string) (map[int]*engine.Snapshot, error) {
+	files, err := engine.ListSnapshots(logFile)
+	if err != nil {
+		return nil, err
+	}
+	snapshots := make(map[int]*engine.Snapshot, len(files))
+	for _, file := range files {
+		if snapshot, err := engine.LoadSnapshot(file); err == nil {
+			snapshots[snapshot.Seq] = snapshot
+		}
+	}
+	return snapshots, nil
+}
@@ -0,0 +1,87 @@
+package timetravel
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ivorytoast/replay78/engine"
+)
+
+// recordRun logs inputs for two games, snapshotting after every other one,
+// and returns the log file
+func recordRun(t *testing.T) string {
+	t.Helper()
+	logFile := filepath.Join(t.TempDir(), "78.log")
+	e := engine.NewEngineWithLogFile(logFile)
+	setupTicTacToe(e)
+	e.SetReplayMode()
+	e.SetSnapshotInterval(2)
+	for _, input := range []engine.Input{
+		{Line: "ttt|new|", Partition: "a"},
+		{Line: "ttt|new|", Partition: "b"},
+		{Line: "ttt|move|0 0 0 0", Partition: "a"},
+		{Line: "ttt|move|1 1 1 1", Partition: "b"},
+	} {
+		e.Apply(input)
+	}
+	e.Close()
+	return logFile
+}
+
+func TestFirstDivergence(t *testing.T) {
+	tests := []struct {
+		name      string
+		corrupt   func(s *engine.Snapshot) // Applied to the first snapshot
+		wantIndex int                      // -1 for no divergence
+		wantGame  string
+	}{
+		{"replay matches", nil, -1, ""},
+		{"partition state differs", func(s *engine.Snapshot) { s.Partitions["b"].CurrentPlayer = 2 }, 1, "b"},
+		{"default game differs", func(s *engine.Snapshot) { s.TicTacToe.Done = true }, 1, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logFile := recordRun(t)
+			if tt.corrupt != nil {
+				files, err := engine.ListSnapshots(logFile)
+				if err != nil || len(files) == 0 {
+					t.Fatalf("no snapshots written: %v", err)
+				}
+				snapshot, err := engine.LoadSnapshot(files[0])
+				if err != nil {
+					t.Fatal(err)
+				}
+				tt.corrupt(snapshot)
+				data, err := json.Marshal(snapshot)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(files[0], data, 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			d, err := FirstDivergence(logFile, setupTicTacToe)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.wantIndex < 0 {
+				if d != nil {
+					t.Errorf("divergence at input %d, want none", d.Index)
+				}
+				return
+			}
+			if d == nil {
+				t.Fatalf("no divergence, want one at input %d", tt.wantIndex)
+			}
+			if d.Index != tt.wantIndex || d.Partition != tt.wantGame {
+				t.Errorf("divergence at input %d in game %q, want input %d in game %q", d.Index, d.Partition, tt.wantIndex, tt.wantGame)
+			}
+			if d.OutputsDiffer() {
+				t.Errorf("outputs differ, want only the state: %q vs %q", d.Expected, d.Actual)
+			}
+		})
+	}
+}
@@ -0,0 +1,253 @@
+package timetravel
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/ivorytoast/replay78/engine"
+	"github.com/ivorytoast/replay78/engine/logreader"
+	"github.com/ivorytoast/replay78/engine/testfile"
+)
+
+// checkpointInterval is how many inputs apart the debugger keeps snapshots
+// to rewind from
+const checkpointInterval = 100
+
+// Debugger replays a list of inputs one at a time on a private engine and
+// can move backwards by rebuilding from the nearest checkpoint
+type Debugger struct {
+	inputs []engine.Input
+	setup  func(*engine.Engine)
+
+	engine      *engine.Engine
+	pos         int                 // Inputs applied so far
+	last        *Step               // Last input applied, nil at the start
+	ends        []int               // Last seq written by each input applied so far
+	checkpoints map[int]*checkpoint // By pos
+	breakpoints []Breakpoint
+}
+
+// checkpoint is the engine state after an input, kept with that input's
+// step so that rewinding to it still reports where the debugger is
+type checkpoint struct {
+	snapshot *engine.Snapshot
+	step     *Step
+}
+
+// Step is the outcome of applying one input
+type Step struct {
+	Index  int // 0-based position of the input
+	Input  engine.Input
+	Result engine.Result
+	Seq    int // Last seq the input wrote
+}
+
+// Breakpoint stops Continue at an input whose topic or action, or one of
+// whose outputs, matches Pattern
+type Breakpoint struct {
+	Field   string // topic, action or output
+	Pattern *regexp.Regexp
+}
+
+func (b Breakpoint) String() string {
+	return b.Field + " " + b.Pattern.String()
+}
+
+func (b Breakpoint) matches(step *Step) bool {
+	fields := strings.SplitN(step.Input.Line, "|", 3)
+	switch b.Field {
+	case "topic":
+		return b.Pattern.MatchString(fields[0])
+	case "action":
+		return len(fields) > 1 && b.Pattern.MatchString(fields[1])
+	case "output":
+		for _, output := range step.Result.Outputs {
+			if b.Pattern.MatchString(output) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// LoadInputs reads the inputs of a log (.log), including lines the engine
+// rejected so that seqs line up with the log, or of a test file, see
+// package testfile
+func LoadInputs(path string) ([]engine.Input, error) {
+	if strings.HasSuffix(path, ".log") {
+		steps, err := logreader.ReadSteps(path)
+		if err != nil {
+			return nil, err
+		}
+		inputs := make([]engine.Input, len(steps))
+		for i, step := range steps {
+			inputs[i] = step.Input
+		}
+		return inputs, nil
+	}
+	f, err := testfile.Parse(path)
+	if err != nil {
+		return nil, err
+	}
+	return f.Inputs, nil
+}
+
+// NewDebugger prepares to replay inputs. setup registers the applications
+// on each engine the debugger builds.
+func NewDebugger(inputs []engine.Input, setup func(*engine.Engine)) *Debugger {
+	d := &Debugger{
+		inputs:      inputs,
+		setup:       setup,
+		checkpoints: make(map[int]*checkpoint),
+	}
+	d.reset(0)
+	return d
+}
+
+// reset starts a fresh engine at pos, which must be 0 or a checkpoint
+func (d *Debugger) reset(pos int) {
+	d.engine = engine.NewEngineWithSink(engine.NewMemorySink())
+	d.engine.SetReplayMode()
+	d.setup(d.engine)
+	d.last = nil
+	if pos > 0 {
+		d.engine.Restore(d.checkpoints[pos].snapshot)
+		d.last = d.checkpoints[pos].step
+	}
+	d.pos = pos
+}
+
+func (d *Debugger) Engine() *engine.Engine {
+	return d.engine
+}
+
+// Position is how many inputs have been applied, out of Len
+func (d *Debugger) Position() int {
+	return d.pos
+}
+
+func (d *Debugger) Len() int {
+	return len(d.inputs)
+}
+
+// Upcoming returns up to n inputs that have not been applied yet
+func (d *Debugger) Upcoming(n int) []engine.Input {
+	end := d.pos + n
+	if end > len(d.inputs) {
+		end = len(d.inputs)
+	}
+	return d.inputs[d.pos:end]
+}
+
+// Step applies the next input, returning false at the end
+func (d *Debugger) Step() (*Step, bool) {
+	if d.pos >= len(d.inputs) {
+		return nil, false
+	}
+	step := &Step{Index: d.pos, Input: d.inputs[d.pos]}
+	step.Result = d.engine.Apply(step.Input)
+	step.Seq = d.engine.Seq()
+
+	if d.pos < len(d.ends) {
+		d.ends[d.pos] = step.Seq
+	} else {
+		d.ends = append(d.ends, step.Seq)
+	}
+	d.pos++
+	d.last = step
+	if d.pos%checkpointInterval == 0 {
+		d.checkpoints[d.pos] = &checkpoint{snapshot: d.engine.Snapshot(), step: step}
+	}
+	return step, true
+}
+
+// Seek moves to the point where exactly pos inputs have been applied,
+// rebuilding from the nearest checkpoint to go backwards. It returns the
+// last input applied, nil at the start.
+func (d *Debugger) Seek(pos int) *Step {
+	if pos < 0 {
+		pos = 0
+	}
+	if pos > len(d.inputs) {
+		pos = len(d.inputs)
+	}
+	if pos < d.pos {
+		nearest := 0
+		for at := range d.checkpoints {
+			if at <= pos && at > nearest {
+				nearest = at
+			}
+		}
+		d.reset(nearest)
+	}
+
+	for d.pos < pos {
+		d.Step()
+	}
+	return d.last
+}
+
+// Back undoes the last n inputs
+func (d *Debugger) Back(n int) *Step {
+	return d.Seek(d.pos - n)
+}
+
+// Goto moves to just after the input that wrote record seq, or to the end
+// if the replay never gets that far
+func (d *Debugger) Goto(seq int) *Step {
+	for i, end := range d.ends {
+		if end >= seq {
+			return d.Seek(i + 1)
+		}
+	}
+	d.Seek(len(d.ends))
+	var last *Step
+	for d.engine.Seq() < seq {
+		step, ok := d.Step()
+		if !ok {
+			break
+		}
+		last = step
+	}
+	return last
+}
+
+// Break adds a breakpoint on field (topic, action or output)
+func (d *Debugger) Break(field, pattern string) error {
+	if field != "topic" && field != "action" && field != "output" {
+		return fmt.Errorf("cannot break on %q, use topic, action or output", field)
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return err
+	}
+	d.breakpoints = append(d.breakpoints, Breakpoint{Field: field, Pattern: re})
+	return nil
+}
+
+func (d *Debugger) Breakpoints() []Breakpoint {
+	return d.breakpoints
+}
+
+func (d *Debugger) ClearBreakpoints() {
+	d.breakpoints = nil
+}
+
+// Continue steps until an input hits a breakpoint or the inputs run out.
+// It returns the last step and the breakpoint hit, if any.
+func (d *Debugger) Continue() (*Step, *Breakpoint) {
+	var last *Step
+	for {
+		step, ok := d.Step()
+		if !ok {
+			return last, nil
+		}
+		last = step
+		for i := range d.breakpoints {
+			if d.breakpoints[i].matches(step) {
+				return step, &d.breakpoints[i]
+			}
+		}
+	}
+}
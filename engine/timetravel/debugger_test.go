@@ -0,0 +1,72 @@
+package timetravel
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/ivorytoast/replay78/apps"
+	"github.com/ivorytoast/replay78/engine"
+)
+
+func setupTicTacToe(e *engine.Engine) {
+	e.RegisterApplication(apps.NewTicTacToeApp(e))
+}
+
+// debugInputs changes the clock, dispatch and a generator before the first
+// checkpoint and keeps going past it
+func debugInputs() []engine.Input {
+	inputs := []engine.Input{
+		{Line: "ttt|new|"},
+		{Line: "tick|tock|1700000000000000000"},
+		{Line: "engine|gen-stop|tick"},
+		{Line: "engine|pause|"},
+	}
+	for len(inputs) < 2*checkpointInterval+10 {
+		inputs = append(inputs, engine.Input{Line: fmt.Sprintf("tick|tock|%d", 1700000000000000000+len(inputs))})
+	}
+	return inputs
+}
+
+func snapshotJSON(t *testing.T, e *engine.Engine) string {
+	t.Helper()
+	data, err := json.Marshal(e.Snapshot())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestDebuggerSeekBackwards(t *testing.T) {
+	tests := []struct {
+		name     string
+		from, to int
+	}{
+		{"onto a checkpoint", 2*checkpointInterval + 5, checkpointInterval},
+		{"just past a checkpoint", 2*checkpointInterval + 5, checkpointInterval + 1},
+		{"between checkpoints", 2*checkpointInterval + 5, 150},
+		{"onto the later checkpoint", 2*checkpointInterval + 5, 2 * checkpointInterval},
+		{"to the start", 50, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := NewDebugger(debugInputs(), setupTicTacToe)
+			d.Seek(tt.from)
+			step := d.Seek(tt.to)
+
+			if tt.to == 0 {
+				if step != nil {
+					t.Errorf("Seek(0) = step %d, want none", step.Index)
+				}
+			} else if step == nil || step.Index != tt.to-1 {
+				t.Fatalf("Seek(%d) = %+v, want the step of input %d", tt.to, step, tt.to-1)
+			}
+
+			straight := NewDebugger(debugInputs(), setupTicTacToe)
+			straight.Seek(tt.to)
+			if got, want := snapshotJSON(t, d.Engine()), snapshotJSON(t, straight.Engine()); got != want {
+				t.Errorf("state after rewinding\n%s\nwant\n%s", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,88 @@
+// Package timetravel rebuilds an engine's state as it was at any seq of a
+// logged run: it restores the newest snapshot taken at or before that seq
+// and applies the logged inputs from there.
+package timetravel
+
+import (
+	"fmt"
+
+	"github.com/ivorytoast/replay78/engine"
+	"github.com/ivorytoast/replay78/engine/logreader"
+)
+
+// State is an engine rebuilt as of the end of record Seq
+type State struct {
+	Engine   *engine.Engine
+	Seq      int
+	Snapshot int // Seq of the snapshot it started from, 0 for the start of the log
+	Applied  int // Inputs applied on top of the snapshot
+}
+
+// Reconstruct rebuilds the state of the run logged at logFile as of seq.
+// An output record's state is that of the input it belongs to. setup
+// registers the run's applications on the fresh engine.
+func Reconstruct(logFile string, seq int, setup func(*engine.Engine)) (*State, error) {
+	e := engine.NewEngineWithSink(engine.NewMemorySink())
+	e.SetReplayMode()
+	setup(e)
+
+	snapshot, err := nearestSnapshot(logFile, seq)
+	if err != nil {
+		return nil, err
+	}
+	state := &State{Engine: e, Seq: seq}
+	if snapshot != nil {
+		e.Restore(snapshot)
+		state.Snapshot = snapshot.Seq
+	}
+
+	reader, err := logreader.Open(logFile)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	if err := reader.Seek(state.Snapshot + 1); err != nil {
+		return nil, err
+	}
+
+	last := state.Snapshot
+	for reader.Next() {
+		record := reader.Record()
+		if record.Seq > seq {
+			return state, nil
+		}
+		last = record.Seq
+		if record.Kind == "I" {
+			e.Apply(record.Input)
+			state.Applied++
+		}
+	}
+	if err := reader.Err(); err != nil {
+		return nil, err
+	}
+	if last < seq {
+		return nil, fmt.Errorf("%s ends at seq %d, before %d", logFile, last, seq)
+	}
+	return state, nil
+}
+
+// nearestSnapshot loads the newest snapshot of logFile at or before seq, or
+// returns nil if there is none
+func nearestSnapshot(logFile string, seq int) (*engine.Snapshot, error) {
+	files, err := engine.ListSnapshots(logFile)
+	if err != nil {
+		return nil, err
+	}
+	var nearest *engine.Snapshot
+	for _, file := range files {
+		snapshot, err := engine.LoadSnapshot(file)
+		if err != nil {
+			continue
+		}
+		if snapshot.Seq > seq {
+			break
+		}
+		nearest = snapshot
+	}
+	return nearest, nil
+}
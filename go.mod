@@ -2,4 +2,4 @@ module github.com/ivorytoast/replay78
 
 go 1.25.2
 
-require github.com/gorilla/websocket v1.5.3 // indirect
+require github.com/gorilla/websocket v1.5.3
@@ -1,245 +1,432 @@
 package main
 
 import (
-	"bufio"
 	"flag"
 	"fmt"
 	"github.com/ivorytoast/replay78/apps"
 	"github.com/ivorytoast/replay78/engine"
+	"github.com/ivorytoast/replay78/engine/ingest"
+	"github.com/ivorytoast/replay78/engine/logdiff"
+	"github.com/ivorytoast/replay78/engine/logreader"
+	"github.com/ivorytoast/replay78/engine/raft"
+	"github.com/ivorytoast/replay78/engine/replication"
+	"github.com/ivorytoast/replay78/engine/shard"
+	"github.com/ivorytoast/replay78/engine/testfile"
+	"github.com/ivorytoast/replay78/engine/timetravel"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"runtime"
+	"strconv"
 	"strings"
-	"time"
+	"syscall"
 )
 
 func main() {
 	regression := flag.Bool("regression", false, "Run regression tests")
+	update := flag.Bool("update", false, "With --regression, re-record every baseline, showing what changed")
+	approve := flag.String("approve", "", "With --regression, re-record the baselines of these tests (comma-separated names)")
+	ci := flag.Bool("ci", false, "With --regression, fail tests that have no baseline instead of recording one")
+	runPattern := flag.String("run", "", "With --regression, only run tests whose name matches this regex")
+	tags := flag.String("tags", "", "With --regression, only run tests tagged with one of these (comma-separated)")
+	list := flag.Bool("list", false, "With --regression, list the selected tests instead of running them")
+	parallel := flag.Int("parallel", runtime.NumCPU(), "With --regression, how many tests to replay at once")
+	reporter := flag.String("reporter", "text", "With --regression, the report format: text, junit, tap or json")
+	reportFile := flag.String("report-file", "", "With --regression, write the report to this file instead of stdout")
+	diffContext := flag.Int("diff-context", 3, "Context lines around each change in regression diffs")
+	diffJSON := flag.String("diff-json", "", "Write the regression diff reports to this file as JSON")
+	leaderAddr := flag.String("leader", "", "Serve the log to replication followers on this address")
+	followAddr := flag.String("follow", "", "Run as a hot standby of the leader at this address")
+	raftID := flag.String("raft-id", "", "Run as this node of a raft cluster, ordering inputs through the replicated log")
+	raftPeers := flag.String("raft-peers", "", "With -raft-id, every cluster node as id=host:port (comma-separated, including this one)")
+	listenTCP := flag.String("listen-tcp", "", "Accept topic|action|payload lines on this TCP address")
+	listenUnix := flag.String("listen-unix", "", "Accept topic|action|payload lines on this Unix socket")
+	tailPath := flag.String("tail", "", "Feed lines appended to this file or named pipe into the engine")
+	shards := flag.Int("shards", 1, "Spread games over this many engines, each logging to 78-shard<i>.log")
+	flag.Func("cron", "Emit an input on a schedule, as \"<cron spec>;topic|action|payload\" (repeatable)", func(value string) error {
+		spec, line, ok := strings.Cut(value, ";")
+		if !ok {
+			return fmt.Errorf("expected \"<cron spec>;topic|action|payload\"")
+		}
+		if err := engine.NewCronGenerator("cron").AddJob(spec, line); err != nil {
+			return err
+		}
+		generatorFlags.cron = append(generatorFlags.cron, [2]string{spec, line})
+		return nil
+	})
 	flag.Parse()
+	generatorFlags.tailPath = *tailPath
 
 	if *regression {
-		regressionTestFiles := discoverFuzzTestBaselines()
-		runRegressionTests(regressionTestFiles)
+		if _, ok := reporters[*reporter]; !ok {
+			fmt.Printf("Unknown reporter %q, use one of %s\n", *reporter, reporterNames())
+			os.Exit(2)
+		}
+		selection, err := newTestSelection(*runPattern, *tags)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(2)
+		}
+		if *list {
+			listTests(selection)
+			return
+		}
+		if *update || *approve != "" {
+			if !updateBaselines(*approve, selection, *diffContext) {
+				os.Exit(1)
+			}
+			return
+		}
+		regressionTestFiles, missing := discoverFuzzTestBaselines(*ci, selection)
+		if !runRegressionTests(regressionTestFiles, missing, *parallel, *diffContext, *diffJSON, *reporter, *reportFile) {
+			os.Exit(1)
+		}
 		return
 	}
 
-	l := engine.NewEngine()
+	if args := flag.Args(); len(args) > 0 {
+		switch args[0] {
+		case "state":
+			printStateAt(args[1:])
+			return
+		case "debug":
+			runDebugger(args[1:])
+			return
+		case "bisect":
+			runBisect(args[1:])
+			return
+		case "diff":
+			runDiff(args[1:])
+			return
+		}
+	}
+
+	var l engine.Submitter
+	if *shards > 1 {
+		if *tailPath != "" || len(generatorFlags.cron) > 0 || *followAddr != "" || *leaderAddr != "" || *raftID != "" {
+			fmt.Println("-shards cannot be combined with -tail, -cron, -leader, -follow or -raft-id")
+			os.Exit(2)
+		}
+		sharded := shard.NewRuntime("78", *shards, setupEngine)
+		sharded.Run()
+		defer sharded.Close()
+		l = sharded
+	} else {
+		e := engine.NewEngine()
+		setupEngine(e)
 
-	app := apps.NewTicTacToeApp(l)
-	l.RegisterApplication(app)
+		if *raftID != "" {
+			if *followAddr != "" || *leaderAddr != "" {
+				fmt.Println("-raft-id cannot be combined with -leader or -follow")
+				os.Exit(2)
+			}
+			if err := startRaftReplica(e, *raftID, *raftPeers); err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+		}
 
-	l.Run()
+		if *followAddr != "" {
+			runFollower(e, *followAddr, *leaderAddr)
+			return
+		}
+
+		if *leaderAddr != "" {
+			if _, err := replication.NewLeader(e, *leaderAddr); err != nil {
+				fmt.Printf("Error starting replication leader: %v\n", err)
+				os.Exit(1)
+			}
+		}
+
+		if *raftID == "" {
+			e.Run()
+		}
+		l = e
+	}
+
+	if *listenTCP != "" || *listenUnix != "" {
+		server := ingest.NewServer(l)
+		defer server.Close()
+		if *listenTCP != "" {
+			if _, err := server.ListenTCP(*listenTCP); err != nil {
+				fmt.Printf("Error listening on %s: %v\n", *listenTCP, err)
+				os.Exit(1)
+			}
+		}
+		if *listenUnix != "" {
+			if err := server.ListenUnix(*listenUnix); err != nil {
+				fmt.Printf("Error listening on %s: %v\n", *listenUnix, err)
+				os.Exit(1)
+			}
+		}
+	}
 
 	args := flag.Args()
-	if len(args) > 0 {
+	switch {
+	case len(args) > 0:
 		replayFromFile(l, args[0])
-	} else {
+	case *listenTCP != "" || *listenUnix != "":
+		waitForSignal()
+	default:
 		interactiveMode(l)
 	}
 }
 
-func discoverFuzzTestBaselines() []string {
-	baselineDir := "fuzz_baselines"
-	fuzzTestDir := "fuzz_tests"
+// generatorFlags are the generators chosen on the command line
+var generatorFlags struct {
+	tailPath string
+	cron     [][2]string // Spec and line of each -cron job
+}
+
+// setupEngine registers the application and the generators chosen on the
+// command line. Every engine, live or replaying, is set up with it: a replay
+// never starts the generators, but engine|generators and the gen-* commands
+// only log what the live run logged if it knows the same ones.
+func setupEngine(e *engine.Engine) {
+	e.RegisterApplication(apps.NewTicTacToeApp(e))
+	if generatorFlags.tailPath != "" {
+		tail := engine.NewFileTailGenerator("tail", generatorFlags.tailPath)
+		tail.FindOffset = logreader.LastOffset
+		e.RegisterGenerator(tail)
+	}
+	if len(generatorFlags.cron) > 0 {
+		cron := engine.NewCronGenerator("cron")
+		for _, job := range generatorFlags.cron {
+			cron.AddJob(job[0], job[1]) // Checked when the flag was parsed
+		}
+		e.RegisterGenerator(cron)
+	}
+}
+
+// waitForSignal keeps a headless node, one fed only through its listeners,
+// serving until it is interrupted
+func waitForSignal() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	fmt.Println("Serving inputs until interrupted")
+	sig := <-signals
+	fmt.Printf("Received %v, shutting down\n", sig)
+}
 
+// printStateAt handles "state <log file> <seq>", printing the game as it
+// stood at that seq of a logged run
+func printStateAt(args []string) {
+	if len(args) != 2 {
+		fmt.Println("Usage: replay78 state <log file> <seq>")
+		os.Exit(2)
+	}
+	seq, err := strconv.Atoi(args[1])
+	if err != nil || seq < 1 {
+		fmt.Printf("Invalid seq %q\n", args[1])
+		os.Exit(2)
+	}
+
+	state, err := timetravel.Reconstruct(args[0], seq, setupEngine)
+	if err != nil {
+		fmt.Printf("Error reconstructing state: %v\n", err)
+		os.Exit(1)
+	}
+
+	if state.Snapshot > 0 {
+		fmt.Printf("State of %s at seq %d (snapshot at seq %d + %d inputs)\n", args[0], seq, state.Snapshot, state.Applied)
+	} else {
+		fmt.Printf("State of %s at seq %d (%d inputs replayed)\n", args[0], seq, state.Applied)
+	}
+	fmt.Print(state.Engine.TTT().Describe())
+}
+
+// discoverFuzzTestBaselines returns the baseline of every selected test. A
+// test without one gets its baseline recorded now, or in CI mode is
+// returned as missing, since nobody has approved what it should log.
+func discoverFuzzTestBaselines(ci bool, selection testSelection) (baselines []string, missing []string) {
 	// Check if fuzz_tests directory exists
 	if _, err := os.Stat(fuzzTestDir); os.IsNotExist(err) {
-		return []string{}
+		return nil, nil
 	}
 
 	// Create baseline directory if it doesn't exist
 	os.MkdirAll(baselineDir, 0755)
 
-	// Find all .txt files in fuzz_tests
-	testFiles, err := filepath.Glob(filepath.Join(fuzzTestDir, "*.txt"))
+	// Find the selected .txt files in fuzz_tests
+	tests, err := selectTests(selection)
 	if err != nil {
 		fmt.Printf("Error reading fuzz tests: %v\n", err)
-		return []string{}
+		return nil, nil
 	}
 
-	var baselineFiles []string
-
-	for _, testFile := range testFiles {
-		// Generate baseline log filename
-		baseName := strings.TrimSuffix(filepath.Base(testFile), ".txt")
-		baselineLog := filepath.Join(baselineDir, baseName+".log")
+	for _, test := range tests {
+		testFile := test.Path
+		baselineLog := baselinePath(testFile)
 
 		// If baseline doesn't exist, create it
 		if _, err := os.Stat(baselineLog); os.IsNotExist(err) {
+			if ci {
+				missing = append(missing, testFile)
+				continue
+			}
 			fmt.Printf("Creating baseline: %s from %s\n", baselineLog, testFile)
-
-			// Create engine with baseline log file
-			l := engine.NewEngineWithLogFile(baselineLog)
-
-			app := apps.NewTicTacToeApp(l)
-			l.RegisterApplication(app)
-
-			l.Run()
-
-			// Read and replay test file
-			file, err := os.Open(testFile)
+			data, err := recordBaseline(testFile)
+			if err == nil {
+				err = os.WriteFile(baselineLog, data, 0644)
+			}
 			if err != nil {
-				fmt.Printf("Error opening test file %s: %v\n", testFile, err)
+				fmt.Printf("Error creating baseline from %s: %v\n", testFile, err)
 				continue
 			}
-
-			scanner := bufio.NewScanner(file)
-			for scanner.Scan() {
-				line := strings.TrimSpace(scanner.Text())
-				if line == "" || strings.HasPrefix(line, "#") {
-					continue
-				}
-				l.In(line)
-			}
-			file.Close()
 		}
 
-		baselineFiles = append(baselineFiles, baselineLog)
+		baselines = append(baselines, baselineLog)
 	}
 
-	return baselineFiles
+	return baselines, missing
 }
 
-func runRegressionTests(logFiles []string) {
-	if len(logFiles) == 0 {
-		return
+// checkExpectations runs the expect lines of the test file a baseline was
+// created from, if it still exists
+func checkExpectations(baseline string) []error {
+	name := strings.TrimSuffix(filepath.Base(baseline), ".log")
+	path := filepath.Join(fuzzTestDir, name+".txt")
+	if _, err := os.Stat(path); err != nil {
+		return nil
+	}
+	test, err := testfile.Parse(path)
+	if err != nil {
+		return []error{err}
 	}
+	return test.Check(setupEngine)
+}
 
-	fmt.Println("=== Running Regression Tests ===")
-	fmt.Println()
-	allPassed := true
+// runRegressionTests replays every baseline and writes the results with
+// the named reporter to reportFile, or to stdout. A report written to a file
+// is accompanied by the text report on stdout.
+func runRegressionTests(logFiles []string, missing []string, workers int, diffContext int, diffJSON string, reporterName string, reportFile string) bool {
+	if len(logFiles) == 0 && len(missing) == 0 {
+		return true
+	}
 
-	// Store replay log names for comparison
-	type testPair struct {
-		original string
-		replay   string
+	var results []*testResult
+	for _, testFile := range missing {
+		name := strings.TrimSuffix(filepath.Base(testFile), ".txt")
+		results = append(results, &testResult{Name: name, Baseline: baselinePath(testFile), Missing: true})
 	}
-	var testPairs []testPair
-
-	// Redirect stdout to suppress game output
-	oldStdout := os.Stdout
-	devNull, _ := os.Open(os.DevNull)
-	os.Stdout = devNull
-
-	// First pass: Create all replay logs
-	for _, logFile := range logFiles {
-		// Skip if file doesn't exist
-		if _, err := os.Stat(logFile); os.IsNotExist(err) {
-			continue
-		}
+	results = append(results, runTests(logFiles, workers, diffContext)...)
 
-		// Parse inputs from original log
-		inputs, err := parseLogForInputs(logFile)
-		if err != nil {
-			// Restore stdout for error message
-			os.Stdout = oldStdout
-			fmt.Printf("  ❌ FAILED - Error reading log: %v\n", err)
-			os.Stdout = devNull
-			allPassed = false
-			continue
+	allPassed := true
+	var reports []*logdiff.Report
+	for _, result := range results {
+		if result.Report != nil {
+			reports = append(reports, result.Report)
 		}
+		allPassed = allPassed && result.Passed()
+	}
 
-		// Create replay log filename - find next available number
-		baseName := strings.TrimSuffix(logFile, ".log")
-		replayBase := baseName + "-replay"
-
-		// Find the highest numbered replay file
-		highestNum := 0
-		for i := 1; i < 1000; i++ {
-			testFile := fmt.Sprintf("%s-%d.log", replayBase, i)
-			if _, err := os.Stat(testFile); err == nil {
-				highestNum = i
-			} else {
-				break
-			}
+	if diffJSON != "" {
+		if err := writeDiffJSON(diffJSON, reports); err != nil {
+			fmt.Printf("Error writing %s: %v\n", diffJSON, err)
 		}
+	}
 
-		replayLogName := fmt.Sprintf("%s-%d.log", replayBase, highestNum+1)
-
-		// Create new engine with custom log file
-		l := engine.NewEngineWithLogFile(replayLogName)
-
-		app := apps.NewTicTacToeApp(l)
-		l.RegisterApplication(app)
-
-		l.Run()
-
-		// Replay inputs
-		for _, input := range inputs {
-			l.In(input)
+	if reportFile == "" {
+		if err := reporters[reporterName](os.Stdout, results); err != nil {
+			fmt.Fprintf(os.Stderr, "Error writing report: %v\n", err)
 		}
-
-		testPairs = append(testPairs, testPair{logFile, replayLogName})
+		return allPassed
+	}
+	writeTextReport(os.Stdout, results)
+	file, err := os.Create(reportFile)
+	if err == nil {
+		err = reporters[reporterName](file, results)
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	if err != nil {
+		fmt.Printf("Error writing %s: %v\n", reportFile, err)
+		return false
 	}
+	return allPassed
+}
 
-	// Wait once for all engines to finish writing
-	time.Sleep(500 * time.Millisecond)
+// runFollower mirrors the leader until it goes away, then promotes this
+// node (serving followers on promoteAddr if set) and continues interactively
+func runFollower(l *engine.Engine, leaderAddr string, promoteAddr string) {
+	l.SetReplayMode()
+	follower := replication.NewFollower(l)
+	l.Run()
 
-	// Restore stdout
-	os.Stdout = oldStdout
-	devNull.Close()
+	err := follower.Follow(leaderAddr)
+	fmt.Printf("Lost leader at seq %d (%v), %d mismatches\n", follower.LastSeq(), err, follower.Mismatches())
 
-	// Second pass: Compare all logs
-	for _, pair := range testPairs {
-		match, err := compareLogFiles(pair.original, pair.replay)
-		if err != nil {
-			fmt.Printf("  ❌ FAILED - Error comparing %s: %v\n", pair.original, err)
-			allPassed = false
-			continue
-		}
+	if _, err := follower.Promote(promoteAddr); err != nil {
+		fmt.Printf("Error serving followers after promotion: %v\n", err)
+	}
+	interactiveMode(l)
+}
 
-		if match {
-			fmt.Printf("  ✅ PASSED - %s\n", filepath.Base(pair.original))
-		} else {
-			fmt.Printf("  ❌ FAILED - %s (output differs from original)\n", filepath.Base(pair.original))
-			allPassed = false
+// startRaftReplica joins e to the raft cluster described by peers, a list of
+// id=host:port, and starts it. Inputs are then only processed once the
+// cluster has committed them, and only the leader accepts new ones.
+func startRaftReplica(e *engine.Engine, id string, peers string) error {
+	transport := &raft.TCPTransport{Addrs: make(map[string]string)}
+	var others []string
+	for _, peer := range strings.Split(peers, ",") {
+		peerID, addr, ok := strings.Cut(strings.TrimSpace(peer), "=")
+		if !ok || peerID == "" || addr == "" {
+			return fmt.Errorf("invalid -raft-peers entry %q, want id=host:port", peer)
+		}
+		transport.Addrs[peerID] = addr
+		if peerID != id {
+			others = append(others, peerID)
 		}
 	}
+	addr, ok := transport.Addrs[id]
+	if !ok {
+		return fmt.Errorf("-raft-peers has no address for this node %q", id)
+	}
 
-	fmt.Println()
-	fmt.Println("================================")
-	if allPassed {
-		fmt.Println("✅ All regression tests PASSED")
-	} else {
-		fmt.Println("❌ Some regression tests FAILED")
+	replica := raft.NewReplica(id, others, transport, e)
+	if _, err := raft.ServeTCP(addr, replica.Node()); err != nil {
+		return fmt.Errorf("error listening for raft on %s: %v", addr, err)
 	}
-	fmt.Println()
+	e.Run()
+	replica.Start()
+	fmt.Printf("Raft node %s listening on %s with %d peers\n", id, addr, len(others))
+	return nil
 }
 
-func replayFromFile(l *engine.Engine, filename string) {
-	file, err := os.Open(filename)
+func replayFromFile(l engine.Submitter, filename string) {
+	test, err := testfile.Parse(filename)
 	if err != nil {
-		fmt.Printf("Error opening file: %v\n", err)
+		fmt.Printf("Error reading file: %v\n", err)
 		return
 	}
-	defer file.Close()
 
-	scanner := bufio.NewScanner(file)
-	for scanner.Scan() {
-		line := strings.TrimSpace(scanner.Text())
-		if line == "" || strings.HasPrefix(line, "#") {
-			continue
-		}
-		fmt.Printf("Processing: %s\n", line)
-		l.In(line)
+	for _, input := range test.Inputs {
+		fmt.Printf("Processing: %s\n", input.Line)
+		l.Submit(input)
 	}
+}
 
-	if err := scanner.Err(); err != nil {
-		fmt.Printf("Error reading file: %v\n", err)
+func interactiveMode(l engine.Submitter) {
+	source := engine.Source{Kind: "cli", User: os.Getenv("USER")}
+	game := ""
+	in := func(line string) {
+		if err := l.Submit(engine.Input{Line: line, Source: source, Partition: game}); err != nil {
+			fmt.Printf("Error: %v\n", err)
+		}
 	}
-}
 
-func interactiveMode(l *engine.Engine) {
 	fmt.Println("=== Tic Tac Toe ===")
 	for {
-		fmt.Println("\nOptions: [n]ew game, [s]how board, [m]ove, [q]uit")
+		fmt.Println("\nOptions: [n]ew game, [s]how board, [m]ove, [g]ame id, [q]uit")
 		fmt.Print("-> ")
 		var choice string
 		fmt.Scanln(&choice)
 		switch choice {
 		case "n", "new":
-			l.In("ttt|new|")
+			in("ttt|new|")
 		case "s", "show":
-			l.In("ttt|show|")
+			in("ttt|show|")
 		case "m", "move":
 			var fromRow, fromCol, toRow, toCol int
 			fmt.Print("Enter from row (0-2): ")
@@ -250,7 +437,11 @@ func interactiveMode(l *engine.Engine) {
 			fmt.Scanln(&toRow)
 			fmt.Print("Enter to col (0-2): ")
 			fmt.Scanln(&toCol)
-			l.In(fmt.Sprintf("ttt|move|%d %d %d %d", fromRow, fromCol, toRow, toCol))
+			in(fmt.Sprintf("ttt|move|%d %d %d %d", fromRow, fromCol, toRow, toCol))
+		case "g", "game":
+			fmt.Print("Enter game id (blank for the default game): ")
+			game = ""
+			fmt.Scanln(&game)
 		case "q", "quit":
 			return
 		default:
@@ -258,58 +449,3 @@ func interactiveMode(l *engine.Engine) {
 		}
 	}
 }
-
-func parseLogForInputs(filename string) ([]string, error) {
-	file, err := os.Open(filename)
-	if err != nil {
-		return nil, err
-	}
-	defer file.Close()
-
-	var inputs []string
-	scanner := bufio.NewScanner(file)
-	for scanner.Scan() {
-		line := scanner.Text()
-		parts := strings.SplitN(line, "|", 3)
-		if len(parts) >= 3 && parts[1] == "I" {
-			inputs = append(inputs, parts[2])
-		}
-	}
-	return inputs, scanner.Err()
-}
-
-func compareLogFiles(originalFile, replayFile string) (bool, error) {
-	original, err := os.ReadFile(originalFile)
-	if err != nil {
-		return false, err
-	}
-	replay, err := os.ReadFile(replayFile)
-	if err != nil {
-		return false, err
-	}
-
-	origLines := strings.Split(string(original), "\n")
-	replayLines := strings.Split(string(replay), "\n")
-
-	if len(origLines) != len(replayLines) {
-		return false, nil
-	}
-
-	for i := range origLines {
-		origParts := strings.SplitN(origLines[i], "|", 2)
-		replayParts := strings.SplitN(replayLines[i], "|", 2)
-
-		if len(origParts) < 2 || len(replayParts) < 2 {
-			if origLines[i] != replayLines[i] {
-				return false, nil
-			}
-			continue
-		}
-
-		if origParts[1] != replayParts[1] {
-			return false, nil
-		}
-	}
-
-	return true, nil
-}
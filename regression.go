@@ -0,0 +1,183 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ivorytoast/replay78/engine"
+	"github.com/ivorytoast/replay78/engine/logdiff"
+	"github.com/ivorytoast/replay78/engine/logreader"
+	"github.com/ivorytoast/replay78/engine/testfile"
+	"github.com/ivorytoast/replay78/engine/timetravel"
+)
+
+// testSelection picks the tests a run covers, see -run and -tags
+type testSelection struct {
+	run  *regexp.Regexp // Matched against the test name, nil for every test
+	tags []string       // A test needs one of these, none for every test
+}
+
+func newTestSelection(run, tags string) (testSelection, error) {
+	var s testSelection
+	if run != "" {
+		re, err := regexp.Compile(run)
+		if err != nil {
+			return s, fmt.Errorf("invalid -run pattern: %v", err)
+		}
+		s.run = re
+	}
+	for _, tag := range strings.Split(tags, ",") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			s.tags = append(s.tags, tag)
+		}
+	}
+	return s, nil
+}
+
+func (s testSelection) matches(name string, test *testfile.File) bool {
+	if s.run != nil && !s.run.MatchString(name) {
+		return false
+	}
+	if len(s.tags) == 0 {
+		return true
+	}
+	for _, tag := range s.tags {
+		if test.HasTag(tag) {
+			return true
+		}
+	}
+	return false
+}
+
+// selectTests returns the test files under fuzz_tests that s picks. A file
+// that does not parse has no tags, and is still picked by name alone.
+func selectTests(s testSelection) ([]*testfile.File, error) {
+	paths, err := filepath.Glob(filepath.Join(fuzzTestDir, "*.txt"))
+	if err != nil {
+		return nil, err
+	}
+	var tests []*testfile.File
+	for _, path := range paths {
+		test, err := testfile.Parse(path)
+		if err != nil {
+			test = &testfile.File{Path: path}
+		}
+		if s.matches(strings.TrimSuffix(filepath.Base(path), ".txt"), test) {
+			tests = append(tests, test)
+		}
+	}
+	return tests, nil
+}
+
+// listTests handles --regression -list, printing the tests a run would
+// cover with their tags and whether they have a baseline yet
+func listTests(s testSelection) {
+	tests, err := selectTests(s)
+	if err != nil {
+		fmt.Printf("Error reading fuzz tests: %v\n", err)
+		return
+	}
+	for _, test := range tests {
+		name := strings.TrimSuffix(filepath.Base(test.Path), ".txt")
+		line := name
+		if len(test.Tags) > 0 {
+			line += " [" + strings.Join(test.Tags, ", ") + "]"
+		}
+		if _, err := os.Stat(baselinePath(test.Path)); os.IsNotExist(err) {
+			line += " (no baseline)"
+		}
+		fmt.Println(line)
+	}
+	fmt.Printf("%d tests\n", len(tests))
+}
+
+// testResult is the outcome of replaying one baseline
+type testResult struct {
+	Name         string
+	Baseline     string
+	Report       *logdiff.Report
+	Divergence   *timetravel.Divergence // Set when the replay differs
+	Expectations []error                // Expect lines of the test file that failed
+	Err          error                  // The test could not run
+	Missing      bool                   // No approved baseline, see --ci
+	ReplayLog    string                 // Written only when the test fails
+	Duration     time.Duration
+}
+
+func (r *testResult) Passed() bool {
+	return r.Err == nil && !r.Missing && r.Report != nil && r.Report.Equal && len(r.Expectations) == 0
+}
+
+// runTests replays every baseline on workers goroutines. Each replay runs
+// to completion on its own in-memory engine, and results come back in the
+// order of baselines whatever order they finish in.
+func runTests(baselines []string, workers int, diffContext int) []*testResult {
+	results := make([]*testResult, len(baselines))
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < max(workers, 1); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				results[i] = runTest(baselines[i], diffContext)
+			}
+		}()
+	}
+	for i := range baselines {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+	return results
+}
+
+// runTest replays a baseline's inputs and compares what the engine logs
+// with the baseline. A failing test leaves its replay next to the baseline
+// as <name>-replay.log, a passing one removes any left from before.
+func runTest(baseline string, diffContext int) *testResult {
+	start := time.Now()
+	name := strings.TrimSuffix(filepath.Base(baseline), ".log")
+	result := &testResult{Name: name, Baseline: baseline}
+	defer func() { result.Duration = time.Since(start) }()
+
+	// Steps rather than inputs, so lines the engine rejected are sent again
+	steps, err := logreader.ReadSteps(baseline)
+	if err != nil {
+		result.Err = err
+		return result
+	}
+
+	sink := engine.NewMemorySink()
+	e := engine.NewEngineWithSink(sink)
+	e.SetReplayMode()
+	setupEngine(e)
+	for _, step := range steps {
+		e.Apply(step.Input)
+	}
+
+	replayLog := strings.TrimSuffix(baseline, ".log") + "-replay.log"
+	result.Report, err = logdiff.CompareRecords(baseline, replayLog, sink.Records(), diffContext)
+	if err != nil {
+		result.Err = err
+		return result
+	}
+	result.Expectations = checkExpectations(baseline)
+	if !result.Report.Equal {
+		result.Divergence, _ = bisectLog(baseline)
+	}
+
+	if result.Passed() {
+		os.Remove(replayLog)
+		return result
+	}
+	if err := os.WriteFile(replayLog, sink.Bytes(), 0644); err == nil {
+		result.ReplayLog = replayLog
+	}
+	return result
+}
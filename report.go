@@ -0,0 +1,246 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ivorytoast/replay78/engine/logdiff"
+)
+
+// reporter writes the results of a regression run in one format
+type reporter func(w io.Writer, results []*testResult) error
+
+var reporters = map[string]reporter{
+	"text":  writeTextReport,
+	"junit": writeJUnitReport,
+	"tap":   writeTAPReport,
+	"json":  writeJSONReport,
+}
+
+// reporterNames lists the formats for usage messages
+func reporterNames() string {
+	names := make([]string, 0, len(reporters))
+	for name := range reporters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// Message is a one-line reason for a failure, "" when the test passed
+func (r *testResult) Message() string {
+	switch {
+	case r.Passed():
+		return ""
+	case r.Missing:
+		return fmt.Sprintf("no approved baseline, run --regression --approve %s", r.Name)
+	case r.Err != nil:
+		return r.Err.Error()
+	case r.Report.Equal:
+		return fmt.Sprintf("%d expectations not met", len(r.Expectations))
+	}
+	return fmt.Sprintf("%d records removed, %d added, first at seq %d",
+		r.Report.Deleted, r.Report.Inserted, r.Report.DivergenceSeq)
+}
+
+// Details is the diff, divergence and failed expectations of a failure
+func (r *testResult) Details() string {
+	var b strings.Builder
+	if r.Report != nil && !r.Report.Equal {
+		r.Report.WriteUnified(&b)
+		if r.Divergence != nil {
+			printDivergence(&b, r.Divergence, "    ")
+		}
+	}
+	for _, failure := range r.Expectations {
+		fmt.Fprintf(&b, "    %v\n", failure)
+	}
+	if r.ReplayLog != "" {
+		fmt.Fprintf(&b, "    Replay written to %s\n", r.ReplayLog)
+	}
+	return b.String()
+}
+
+// DivergenceSeq is the seq in the baseline where the replay first differs
+func (r *testResult) DivergenceSeq() int {
+	if r.Report == nil {
+		return 0
+	}
+	return r.Report.DivergenceSeq
+}
+
+func writeTextReport(w io.Writer, results []*testResult) error {
+	fmt.Fprintln(w, "=== Running Regression Tests ===")
+	fmt.Fprintln(w)
+	allPassed := true
+	for _, result := range results {
+		if result.Passed() {
+			fmt.Fprintf(w, "  ✅ PASSED - %s\n", filepath.Base(result.Baseline))
+			continue
+		}
+		allPassed = false
+		fmt.Fprintf(w, "  ❌ FAILED - %s (%s)\n", filepath.Base(result.Baseline), result.Message())
+		fmt.Fprint(w, result.Details())
+	}
+
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "================================")
+	if allPassed {
+		fmt.Fprintln(w, "✅ All regression tests PASSED")
+	} else {
+		fmt.Fprintln(w, "❌ Some regression tests FAILED")
+	}
+	_, err := fmt.Fprintln(w)
+	return err
+}
+
+type junitSuites struct {
+	XMLName xml.Name     `xml:"testsuites"`
+	Suites  []junitSuite `xml:"testsuite"`
+}
+
+type junitSuite struct {
+	Name     string      `xml:"name,attr"`
+	Tests    int         `xml:"tests,attr"`
+	Failures int         `xml:"failures,attr"`
+	Errors   int         `xml:"errors,attr"`
+	Time     string      `xml:"time,attr"`
+	Cases    []junitCase `xml:"testcase"`
+}
+
+type junitCase struct {
+	Name       string          `xml:"name,attr"`
+	Classname  string          `xml:"classname,attr"`
+	Time       string          `xml:"time,attr"`
+	Properties []junitProperty `xml:"properties>property,omitempty"`
+	Failure    *junitFailure   `xml:"failure,omitempty"`
+	Error      *junitFailure   `xml:"error,omitempty"`
+}
+
+type junitProperty struct {
+	Name  string `xml:"name,attr"`
+	Value string `xml:"value,attr"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr"`
+	Body    string `xml:",cdata"`
+}
+
+func seconds(d time.Duration) string {
+	return fmt.Sprintf("%.3f", d.Seconds())
+}
+
+func writeJUnitReport(w io.Writer, results []*testResult) error {
+	suite := junitSuite{Name: "regression", Tests: len(results)}
+	var total time.Duration
+	for _, result := range results {
+		total += result.Duration
+		c := junitCase{Name: result.Name, Classname: "regression", Time: seconds(result.Duration)}
+		if seq := result.DivergenceSeq(); seq != 0 {
+			c.Properties = []junitProperty{{Name: "divergenceSeq", Value: fmt.Sprint(seq)}}
+		}
+		switch {
+		case result.Passed():
+		case result.Err != nil:
+			suite.Errors++
+			c.Error = &junitFailure{Message: result.Message(), Type: "error"}
+		default:
+			suite.Failures++
+			kind := "divergence"
+			if result.Missing {
+				kind = "missing-baseline"
+			} else if result.Report.Equal {
+				kind = "expectation"
+			}
+			c.Failure = &junitFailure{Message: result.Message(), Type: kind, Body: result.Details()}
+		}
+		suite.Cases = append(suite.Cases, c)
+	}
+	suite.Time = seconds(total)
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	if err := encoder.Encode(junitSuites{Suites: []junitSuite{suite}}); err != nil {
+		return err
+	}
+	_, err := fmt.Fprintln(w)
+	return err
+}
+
+// writeTAPReport writes TAP version 13, with a YAML block of diagnostics
+// under each failed test
+func writeTAPReport(w io.Writer, results []*testResult) error {
+	fmt.Fprintln(w, "TAP version 13")
+	fmt.Fprintf(w, "1..%d\n", len(results))
+	for i, result := range results {
+		if result.Passed() {
+			fmt.Fprintf(w, "ok %d - %s # time=%dms\n", i+1, result.Name, result.Duration.Milliseconds())
+			continue
+		}
+		fmt.Fprintf(w, "not ok %d - %s # time=%dms\n", i+1, result.Name, result.Duration.Milliseconds())
+		fmt.Fprintln(w, "  ---")
+		fmt.Fprintf(w, "  message: %q\n", result.Message())
+		if seq := result.DivergenceSeq(); seq != 0 {
+			fmt.Fprintf(w, "  divergenceSeq: %d\n", seq)
+		}
+		if details := result.Details(); details != "" {
+			fmt.Fprintln(w, "  details: |")
+			for _, line := range strings.Split(strings.TrimRight(details, "\n"), "\n") {
+				fmt.Fprintf(w, "    %s\n", line)
+			}
+		}
+		fmt.Fprintln(w, "  ...")
+	}
+	return nil
+}
+
+type jsonResult struct {
+	Name          string          `json:"name"`
+	Baseline      string          `json:"baseline"`
+	Passed        bool            `json:"passed"`
+	DurationMs    float64         `json:"durationMs"`
+	Message       string          `json:"message,omitempty"`
+	DivergenceSeq int             `json:"divergenceSeq,omitempty"`
+	Expectations  []string        `json:"expectations,omitempty"`
+	ReplayLog     string          `json:"replayLog,omitempty"`
+	Diff          *logdiff.Report `json:"diff,omitempty"`
+}
+
+func writeJSONReport(w io.Writer, results []*testResult) error {
+	out := make([]jsonResult, 0, len(results))
+	for _, result := range results {
+		r := jsonResult{
+			Name:          result.Name,
+			Baseline:      result.Baseline,
+			Passed:        result.Passed(),
+			DurationMs:    float64(result.Duration.Microseconds()) / 1000,
+			Message:       result.Message(),
+			DivergenceSeq: result.DivergenceSeq(),
+			ReplayLog:     result.ReplayLog,
+		}
+		for _, failure := range result.Expectations {
+			r.Expectations = append(r.Expectations, failure.Error())
+		}
+		if result.Report != nil && !result.Report.Equal {
+			r.Diff = result.Report
+		}
+		out = append(out, r)
+	}
+	data, err := json.MarshalIndent(out, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(data, '\n'))
+	return err
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ivorytoast/replay78/engine/logdiff"
+)
+
+// sampleResults has one test of every outcome
+func sampleResults() []*testResult {
+	equal := &logdiff.Report{Equal: true}
+	diverged := &logdiff.Report{
+		Original:      "fuzz_baselines/diverged.log",
+		Replay:        "fuzz_baselines/diverged-replay.log",
+		Deleted:       1,
+		Inserted:      1,
+		DivergenceSeq: 4,
+		Hunks: []logdiff.Hunk{{
+			OriginalStart: 4, OriginalLines: 1, ReplayStart: 4, ReplayLines: 1, Input: "ttt|show|",
+			Lines: []logdiff.Line{
+				{Op: logdiff.Delete, Text: "O|old", OriginalSeq: 4},
+				{Op: logdiff.Insert, Text: "O|new", ReplaySeq: 4},
+			},
+		}},
+	}
+	return []*testResult{
+		{Name: "passed", Baseline: "fuzz_baselines/passed.log", Report: equal, Duration: 12 * time.Millisecond},
+		{Name: "diverged", Baseline: "fuzz_baselines/diverged.log", Report: diverged, ReplayLog: "fuzz_baselines/diverged-replay.log"},
+		{Name: "expectation", Baseline: "fuzz_baselines/expectation.log", Report: equal, Expectations: []error{errors.New("test.txt:3: expect turn 2: it is player 1's turn")}},
+		{Name: "broken", Baseline: "fuzz_baselines/broken.log", Err: errors.New("open fuzz_baselines/broken.log: permission denied")},
+		{Name: "missing", Baseline: "fuzz_baselines/missing.log", Missing: true},
+	}
+}
+
+func TestReporters(t *testing.T) {
+	tests := []struct {
+		format string
+		check  func(t *testing.T, out string)
+	}{
+		{"text", func(t *testing.T, out string) {
+			for _, want := range []string{"✅ PASSED - passed.log", "❌ FAILED - diverged.log (1 records removed, 1 added, first at seq 4)", "-O|old", "+O|new", "Replay written to fuzz_baselines/diverged-replay.log", "❌ FAILED - expectation.log (1 expectations not met)", "❌ Some regression tests FAILED"} {
+				if !strings.Contains(out, want) {
+					t.Errorf("report lacks %q", want)
+				}
+			}
+		}},
+		{"junit", func(t *testing.T, out string) {
+			var suites junitSuites
+			if err := xml.Unmarshal([]byte(out), &suites); err != nil {
+				t.Fatal(err)
+			}
+			if len(suites.Suites) != 1 {
+				t.Fatalf("%d suites, want 1", len(suites.Suites))
+			}
+			suite := suites.Suites[0]
+			if suite.Tests != 5 || suite.Failures != 3 || suite.Errors != 1 {
+				t.Errorf("tests %d, failures %d, errors %d; want 5, 3, 1", suite.Tests, suite.Failures, suite.Errors)
+			}
+			kinds := map[string]string{}
+			for _, c := range suite.Cases {
+				switch {
+				case c.Failure != nil:
+					kinds[c.Name] = c.Failure.Type
+				case c.Error != nil:
+					kinds[c.Name] = c.Error.Type
+				default:
+					kinds[c.Name] = "passed"
+				}
+				if c.Name == "diverged" && (len(c.Properties) != 1 || c.Properties[0].Value != "4") {
+					t.Errorf("diverged properties %+v, want divergenceSeq 4", c.Properties)
+				}
+			}
+			want := map[string]string{"passed": "passed", "diverged": "divergence", "expectation": "expectation", "broken": "error", "missing": "missing-baseline"}
+			for name, kind := range want {
+				if kinds[name] != kind {
+					t.Errorf("case %s is %q, want %q", name, kinds[name], kind)
+				}
+			}
+		}},
+		{"tap", func(t *testing.T, out string) {
+			lines := strings.Split(out, "\n")
+			if lines[0] != "TAP version 13" || lines[1] != "1..5" {
+				t.Fatalf("header %q, want TAP version 13 and plan 1..5", lines[:2])
+			}
+			var verdicts []string
+			for _, line := range lines {
+				if strings.HasPrefix(line, "ok ") || strings.HasPrefix(line, "not ok ") {
+					verdict, _, _ := strings.Cut(line, " #")
+					verdicts = append(verdicts, verdict)
+				}
+			}
+			want := []string{"ok 1 - passed", "not ok 2 - diverged", "not ok 3 - expectation", "not ok 4 - broken", "not ok 5 - missing"}
+			if strings.Join(verdicts, "\n") != strings.Join(want, "\n") {
+				t.Errorf("verdicts %q, want %q", verdicts, want)
+			}
+			if !strings.Contains(out, "  divergenceSeq: 4\n") || strings.Count(out, "  ---\n") != 4 || strings.Count(out, "  ...\n") != 4 {
+				t.Errorf("failures lack their YAML blocks:\n%s", out)
+			}
+		}},
+		{"json", func(t *testing.T, out string) {
+			var results []jsonResult
+			if err := json.Unmarshal([]byte(out), &results); err != nil {
+				t.Fatal(err)
+			}
+			if len(results) != 5 {
+				t.Fatalf("%d results, want 5", len(results))
+			}
+			for _, r := range results {
+				if r.Passed != (r.Name == "passed") || (r.Message == "") != r.Passed {
+					t.Errorf("%s: passed %t with message %q", r.Name, r.Passed, r.Message)
+				}
+			}
+			if d := results[1]; d.DivergenceSeq != 4 || d.Diff == nil || len(d.Diff.Hunks) != 1 {
+				t.Errorf("diverged = %+v, want seq 4 and its diff", d)
+			}
+			if e := results[2]; len(e.Expectations) != 1 || e.Diff != nil {
+				t.Errorf("expectation = %+v, want one failed expectation and no diff", e)
+			}
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			var out bytes.Buffer
+			if err := reporters[tt.format](&out, sampleResults()); err != nil {
+				t.Fatal(err)
+			}
+			tt.check(t, out.String())
+		})
+	}
+	if len(reporters) != len(tests) {
+		t.Errorf("%d reporters, %d tested", len(reporters), len(tests))
+	}
+}
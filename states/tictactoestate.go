@@ -1,10 +1,36 @@
 package states
 
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
 type Cell struct {
 	Player int
 	Power  int
 }
 
+// String renders a cell as the board output shows it: "." when empty,
+// otherwise the player's mark followed by its power when above 1
+func (c Cell) String() string {
+	mark := "."
+	switch c.Player {
+	case 1:
+		mark = "X"
+	case 2:
+		mark = "O"
+	default:
+		return mark
+	}
+	if c.Power == 1 {
+		return mark
+	}
+	return fmt.Sprintf("%s%d", mark, c.Power)
+}
+
 type TurnPhase int
 
 const (
@@ -12,6 +38,13 @@ const (
 	PhaseMovement   TurnPhase = 1 // Optional movement action
 )
 
+func (p TurnPhase) String() string {
+	if p == PhaseMovement {
+		return "Movement (optional)"
+	}
+	return "Assignment"
+}
+
 type TicTacToeState struct {
 	Board                  [][]Cell
 	CurrentPlayer          int
@@ -131,3 +164,87 @@ func (tts *TicTacToeState) IsMovementActionTaken() bool {
 func (tts *TicTacToeState) SetMovementActionTaken(taken bool) {
 	tts.MovementActionTaken = taken
 }
+
+// CountLines counts the rows, columns and diagonals a player fully holds
+func (tts *TicTacToeState) CountLines(player int) int {
+	b := tts.Board
+	count := 0
+	for i := 0; i < 3; i++ {
+		if b[i][0].Player == player && b[i][1].Player == player && b[i][2].Player == player {
+			count++
+		}
+		if b[0][i].Player == player && b[1][i].Player == player && b[2][i].Player == player {
+			count++
+		}
+	}
+	if b[0][0].Player == player && b[1][1].Player == player && b[2][2].Player == player {
+		count++
+	}
+	if b[0][2].Player == player && b[1][1].Player == player && b[2][0].Player == player {
+		count++
+	}
+	return count
+}
+
+// Clone returns a deep copy, safe to keep after the engine moves on
+func (tts *TicTacToeState) Clone() *TicTacToeState {
+	clone := *tts
+	clone.Board = make([][]Cell, len(tts.Board))
+	for i, row := range tts.Board {
+		clone.Board[i] = append([]Cell(nil), row...)
+	}
+	return &clone
+}
+
+// Describe renders the board as a grid followed by the power banks, whose
+// turn it is and the phase of that turn
+func (tts *TicTacToeState) Describe() string {
+	var b strings.Builder
+	for _, row := range tts.Board {
+		cells := make([]string, len(row))
+		for i, cell := range row {
+			cells[i] = fmt.Sprintf("%-2s", cell)
+		}
+		b.WriteString(strings.TrimRight(strings.Join(cells, " "), " ") + "\n")
+	}
+	fmt.Fprintf(&b, "Player 1 (X) Power Bank: %d\n", tts.Player1PowerBank)
+	fmt.Fprintf(&b, "Player 2 (O) Power Bank: %d\n", tts.Player2PowerBank)
+	fmt.Fprintf(&b, "Current Turn: Player %d\n", tts.CurrentPlayer)
+	fmt.Fprintf(&b, "Current Phase: %s\n", tts.CurrentPhase)
+	if tts.Done {
+		b.WriteString("Game over\n")
+	}
+	return b.String()
+}
+
+// Hash is a short digest of the whole state, equal for equal states
+func (tts *TicTacToeState) Hash() string {
+	data, _ := json.Marshal(tts)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:8])
+}
+
+// Diff lists the fields that differ between tts and other, one line each
+// in the form "field: tts value -> other value"
+func (tts *TicTacToeState) Diff(other *TicTacToeState) []string {
+	var diff []string
+	add := func(field string, from, to any) {
+		if from != to {
+			diff = append(diff, fmt.Sprintf("%s: %v -> %v", field, from, to))
+		}
+	}
+	for r, row := range tts.Board {
+		for c, cell := range row {
+			add(fmt.Sprintf("Board[%d][%d]", r, c), cell, other.Board[r][c])
+		}
+	}
+	add("CurrentPlayer", tts.CurrentPlayer, other.CurrentPlayer)
+	add("CurrentPhase", tts.CurrentPhase, other.CurrentPhase)
+	add("MovementActionTaken", tts.MovementActionTaken, other.MovementActionTaken)
+	add("Player1PowerBank", tts.Player1PowerBank, other.Player1PowerBank)
+	add("Player2PowerBank", tts.Player2PowerBank, other.Player2PowerBank)
+	add("Player1FirstTurnDone", tts.Player1FirstTurnDone, other.Player1FirstTurnDone)
+	add("Player2FirstTurnDone", tts.Player2FirstTurnDone, other.Player2FirstTurnDone)
+	add("Done", tts.Done, other.Done)
+	return diff
+}
@@ -55,6 +55,8 @@ function connect() {
             };
             renderBoard();
             updateGameInfo();
+        } else if (data.type === 'error') {
+            setStatus('Server busy: ' + data.message);
         }
     };
 